@@ -1,3 +1,5 @@
 module github.com/ndsky1003/cmd/common
 
 go 1.22
+
+require github.com/klauspost/compress v1.18.0
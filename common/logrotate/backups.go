@@ -0,0 +1,69 @@
+package logrotate
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Backup is a rotated log file in the backup directory.
+type Backup struct {
+	Name string
+	Path string
+	// Time is when the file was rotated, taken from its name. The backup
+	// holds what was written between the previous backup's Time and this.
+	Time       time.Time
+	Size       int64
+	Compressed bool
+}
+
+// Backups returns the backups of the Logger, oldest first.
+func (l *Logger) Backups() ([]Backup, error) {
+	files, err := l.oldLogFiles()
+	if err != nil {
+		return nil, err
+	}
+	backups := make([]Backup, len(files))
+	for i, f := range files {
+		// oldLogFiles is newest first.
+		backups[len(files)-1-i] = Backup{
+			Name:       f.Name(),
+			Path:       filepath.Join(l.backupDir(), f.Name()),
+			Time:       l.localize(f.timestamp),
+			Size:       f.Size(),
+			Compressed: codecFromName(f.Name()) != nil,
+		}
+	}
+	return backups, nil
+}
+
+// OpenBackup opens a backup for reading, decompressing it if its name ends
+// with the suffix of a supported codec.
+func OpenBackup(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	c := codecFromName(path)
+	if c == nil {
+		return f, nil
+	}
+	r, err := c.newReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &decompressor{ReadCloser: r, f: f}, nil
+}
+
+// decompressor closes the file under a codec's reader.
+type decompressor struct {
+	io.ReadCloser
+	f *os.File
+}
+
+func (d *decompressor) Close() error {
+	d.ReadCloser.Close()
+	return d.f.Close()
+}
@@ -0,0 +1,98 @@
+package logrotate
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+type chownCall struct {
+	name     string
+	uid, gid int
+}
+
+// fakeChown records the calls to osChown instead of changing owners.
+func fakeChown(t *testing.T) *[]chownCall {
+	t.Helper()
+	var calls []chownCall
+	old := osChown
+	osChown = func(name string, uid, gid int) error {
+		calls = append(calls, chownCall{name, uid, gid})
+		return nil
+	}
+	t.Cleanup(func() { osChown = old })
+	return &calls
+}
+
+func TestRotateKeepsOwner(t *testing.T) {
+	useFakeTime(t)
+	calls := fakeChown(t)
+	dir := t.TempDir()
+	l := New(filepath.Join(dir, "app.log"), Options().SetMaxSize(10))
+	defer l.Close()
+	mustWrite(t, l, "first....\n")
+	info, err := os.Stat(l.Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat := info.Sys().(*syscall.Stat_t)
+
+	mustWrite(t, l, "second...\n")
+	want := chownCall{l.Filename, int(stat.Uid), int(stat.Gid)}
+	if len(*calls) != 1 || (*calls)[0] != want {
+		t.Errorf("chown calls = %+v, want %+v", *calls, want)
+	}
+}
+
+func TestCompressKeepsOwner(t *testing.T) {
+	calls := fakeChown(t)
+	dir := t.TempDir()
+	src := filepath.Join(dir, "app-2024-01-02T03-04-05.000.log")
+	if err := os.WriteFile(src, []byte("line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat := info.Sys().(*syscall.Stat_t)
+	if err := compressLogFile(src, src+".gz", codecs[CompressGzip], 0); err != nil {
+		t.Fatal(err)
+	}
+	want := chownCall{src + ".gz", int(stat.Uid), int(stat.Gid)}
+	if len(*calls) != 1 || (*calls)[0] != want {
+		t.Errorf("chown calls = %+v, want %+v", *calls, want)
+	}
+}
+
+func TestRotateKeepsOwnerAsRoot(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing the owner of a file needs root")
+	}
+	useFakeTime(t)
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(name, []byte("first....\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	const uid, gid = 65534, 65534
+	if err := os.Chown(name, uid, gid); err != nil {
+		t.Fatal(err)
+	}
+	l := New(name, Options().SetMaxSize(10))
+	defer l.Close()
+	mustWrite(t, l, "second...\n")
+
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat := info.Sys().(*syscall.Stat_t)
+	if stat.Uid != uid || stat.Gid != gid {
+		t.Errorf("new file owned by %d:%d, want %d:%d", stat.Uid, stat.Gid, uid, gid)
+	}
+	if got := readFile(t, name); got != "second...\n" {
+		t.Errorf("new file = %q", got)
+	}
+}
@@ -0,0 +1,110 @@
+package logrotate
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Values for Logger.Compression.
+const (
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+	CompressNone = "none"
+)
+
+// codec compresses backups and reads them back.
+type codec struct {
+	name   string
+	suffix string
+	// minLevel and maxLevel bound the levels newWriter accepts besides 0.
+	minLevel, maxLevel int
+	// newWriter returns a writer compressing into w at level, where 0 means
+	// the codec's default level.
+	newWriter func(w io.Writer, level int) (io.WriteCloser, error)
+	newReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+// codecs holds every supported codec by name. Backups written with any of
+// them are recognized by oldLogFiles, so retention keeps working after the
+// configured codec changes.
+var codecs = map[string]*codec{
+	CompressGzip: {
+		name:     CompressGzip,
+		suffix:   ".gz",
+		minLevel: gzip.HuffmanOnly,
+		maxLevel: gzip.BestCompression,
+		newWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			if level == 0 {
+				level = gzip.DefaultCompression
+			}
+			return gzip.NewWriterLevel(w, level)
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+	CompressZstd: {
+		name:     CompressZstd,
+		suffix:   ".zst",
+		minLevel: 1,
+		maxLevel: 22,
+		newWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			opts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+			if level != 0 {
+				opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+			}
+			return zstd.NewWriter(w, opts...)
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+	},
+}
+
+// codec returns the codec used to compress backups, or nil if backups are
+// not compressed. Compression selects the codec; when it is empty, Compress
+// selects gzip.
+func (l *Logger) codec() (*codec, error) {
+	name := l.Compression
+	if name == "" {
+		if !l.Compress {
+			return nil, nil
+		}
+		name = CompressGzip
+	}
+	if name == CompressNone {
+		return nil, nil
+	}
+	c, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression: %s", name)
+	}
+	return c, nil
+}
+
+// codecFromName returns the codec whose suffix name ends with, or nil if
+// name is not compressed.
+func codecFromName(name string) *codec {
+	for _, c := range codecs {
+		if strings.HasSuffix(name, c.suffix) {
+			return c
+		}
+	}
+	return nil
+}
+
+// trimCompressSuffix strips any known compression suffix from name.
+func trimCompressSuffix(name string) string {
+	if c := codecFromName(name); c != nil {
+		return strings.TrimSuffix(name, c.suffix)
+	}
+	return name
+}
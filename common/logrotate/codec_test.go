@@ -0,0 +1,125 @@
+package logrotate
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoggerCodec(t *testing.T) {
+	tests := []struct {
+		compress    bool
+		compression string
+		suffix      string // "" for no compression
+		wantErr     bool
+	}{
+		{false, "", "", false},
+		{true, "", ".gz", false},
+		{false, CompressGzip, ".gz", false},
+		{false, CompressZstd, ".zst", false},
+		{true, CompressZstd, ".zst", false},
+		{true, CompressNone, "", false},
+		{false, "brotli", "", true},
+	}
+	for _, tt := range tests {
+		l := &Logger{Compress: tt.compress, Compression: tt.compression}
+		c, err := l.codec()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("codec(%v, %q) error = %v, want error %v", tt.compress, tt.compression, err, tt.wantErr)
+			continue
+		}
+		suffix := ""
+		if c != nil {
+			suffix = c.suffix
+		}
+		if suffix != tt.suffix {
+			t.Errorf("codec(%v, %q) suffix = %q, want %q", tt.compress, tt.compression, suffix, tt.suffix)
+		}
+	}
+}
+
+func TestCodecFromName(t *testing.T) {
+	tests := []struct {
+		name, trimmed string
+		compressed    bool
+	}{
+		{"app-2024-01-02T03-04-05.000.log", "app-2024-01-02T03-04-05.000.log", false},
+		{"app-2024-01-02T03-04-05.000.log.gz", "app-2024-01-02T03-04-05.000.log", true},
+		{"app-2024-01-02T03-04-05.000.log.zst", "app-2024-01-02T03-04-05.000.log", true},
+	}
+	for _, tt := range tests {
+		if got := codecFromName(tt.name) != nil; got != tt.compressed {
+			t.Errorf("codecFromName(%s) compressed = %v, want %v", tt.name, got, tt.compressed)
+		}
+		if got := trimCompressSuffix(tt.name); got != tt.trimmed {
+			t.Errorf("trimCompressSuffix(%s) = %s, want %s", tt.name, got, tt.trimmed)
+		}
+	}
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	content := strings.Repeat("a line of log output\n", 1000)
+	for name, c := range codecs {
+		for _, level := range []int{0, 1, 9} {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "app-2024-01-02T03-04-05.000.log")
+			if err := os.WriteFile(src, []byte(content), 0640); err != nil {
+				t.Fatal(err)
+			}
+			dst := src + c.suffix
+			if err := compressLogFile(src, dst, c, level); err != nil {
+				t.Fatalf("%s level %d: %v", name, level, err)
+			}
+			if _, err := os.Stat(src); !os.IsNotExist(err) {
+				t.Errorf("%s level %d: source not removed", name, level)
+			}
+			info, err := os.Stat(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0640 {
+				t.Errorf("%s level %d: mode = %v, want 0640", name, level, info.Mode().Perm())
+			}
+			if info.Size() >= int64(len(content)) {
+				t.Errorf("%s level %d: compressed size %d not smaller than %d", name, level, info.Size(), len(content))
+			}
+			r, err := OpenBackup(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(r)
+			r.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != content {
+				t.Errorf("%s level %d: round trip changed the content", name, level)
+			}
+		}
+	}
+}
+
+func TestCompressionRetentionAcrossCodecs(t *testing.T) {
+	// backups written by a previously configured codec still count towards
+	// MaxBackups.
+	useFakeTime(t)
+	dir := t.TempDir()
+	for _, name := range []string{
+		"app-2024-01-01T00-00-00.000.log.gz",
+		"app-2024-01-01T00-00-01.000.log.zst",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l := New(filepath.Join(dir, "app.log"), Options().SetMaxSize(10).SetMaxBackups(1).SetCompression(CompressZstd))
+	defer l.Close()
+	mustWrite(t, l, "first....\n")
+	mustWrite(t, l, "second...\n")
+	eventually(t, "pruning", func() bool {
+		got := names(t, dir)
+		return strings.Join(got, " ") == "app-2024-01-02T03-04-05.000.log.zst app.log"
+	})
+}
@@ -0,0 +1,812 @@
+// Package logrotate provides a rolling file writer, derived from lumberjack.
+//
+// A Logger writes to Filename and rotates it into BackDir when it reaches
+// MaxSize or a wall-clock boundary (RotateEvery). Backups are compressed and
+// pruned in the background according to MaxBackups, MaxAge, MaxTotalSize and
+// MinFreeSpace. On Linux rotated files keep the owner of the original file.
+//
+//	l := logrotate.New("app.log", logrotate.Options().SetBackDir("log").SetMaxSize(100).SetCompression(logrotate.CompressZstd))
+//	defer l.Close()
+//	slog.SetDefault(slog.New(slog.NewTextHandler(l, nil)))
+package logrotate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	backupTimeFormat   = "2006-01-02T15-04-05.000"
+	defaultMaxSize     = 100
+	spaceCheckInterval = time.Minute
+)
+
+// Values for Logger.Oversize.
+const (
+	OversizeSplit    = "split"
+	OversizeTruncate = "truncate"
+)
+
+// truncatedMark ends a write cut short by OversizeTruncate.
+const truncatedMark = "\n...[truncated]\n"
+
+// Values for Logger.RotateEvery.
+const (
+	RotateHourly = "hourly"
+	RotateDaily  = "daily"
+)
+
+var _ io.WriteCloser = (*Logger)(nil)
+
+type Logger struct {
+	// BackDir is the directory holding backups. Rotation moves the current
+	// file into it, and compression and the retention rules only look
+	// there. Empty means the directory of Filename.
+	BackDir  string `json:"backdir" yaml:"backdir"`
+	Filename string `json:"filename" yaml:"filename"`
+
+	MaxSize int `json:"maxsize" yaml:"maxsize"`
+
+	MaxAge int `json:"maxage" yaml:"maxage"`
+
+	MaxBackups int `json:"maxbackups" yaml:"maxbackups"`
+
+	LocalTime bool `json:"localtime" yaml:"localtime"`
+
+	Compress bool `json:"compress" yaml:"compress"`
+
+	// Compression selects the codec for compressed backups: CompressGzip,
+	// CompressZstd or CompressNone. Empty means gzip if Compress is set.
+	// Setting a codec enables compression without Compress.
+	Compression string `json:"compression" yaml:"compression"`
+
+	// CompressLevel is the codec's compression level, 0 for its default:
+	// -2 to 9 for gzip (see compress/flate), 1 to 22 for zstd.
+	CompressLevel int `json:"compresslevel" yaml:"compresslevel"`
+
+	// RotateEvery rotates the log file at wall-clock boundaries, either
+	// RotateHourly or RotateDaily, in local time if LocalTime is set and UTC
+	// otherwise. It works alongside MaxSize: whichever triggers first wins.
+	// The empty string disables time based rotation.
+	RotateEvery string `json:"rotateevery" yaml:"rotateevery"`
+
+	// MaxTotalSize is the maximum size in megabytes of the current log file
+	// plus all of its backups, compressed or not. The oldest backups are
+	// removed first until the total fits. 0 disables the budget.
+	MaxTotalSize int `json:"maxtotalsize" yaml:"maxtotalsize"`
+
+	// MinFreeSpace is the free space in megabytes to keep on the filesystem
+	// holding the backups. While free space is below it the oldest backups
+	// are removed, and Write checks it every spaceCheckInterval so pruning
+	// does not have to wait for the next rotation. 0 disables the guard.
+	MinFreeSpace int `json:"minfreespace" yaml:"minfreespace"`
+
+	// Oversize decides what happens to a single write larger than MaxSize.
+	// OversizeSplit, the default, spreads it over as many files as needed,
+	// cutting at the last newline that fits where possible.
+	// OversizeTruncate keeps the first MaxSize bytes, ending them with
+	// truncatedMark, and drops the rest.
+	Oversize string `json:"oversize" yaml:"oversize"`
+
+	// OnDrop, if set, is called with the number of bytes a write dropped.
+	// It runs after the Logger is unlocked, so it may log into the Logger
+	// itself.
+	OnDrop func(dropped int) `json:"-" yaml:"-" toml:"-"`
+
+	// Manifest keeps <name>.manifest.json in the backup directory, listing
+	// each finished backup with its time range, size, line count and
+	// SHA-256, so Verify can later detect truncated or altered archives.
+	Manifest bool `json:"manifest" yaml:"manifest"`
+
+	// OnArchive, if set, is called by the mill goroutine with the path of
+	// each finished backup: after it is compressed, or right after rotation
+	// when compression is off. Backups compressed later, such as ones left
+	// uncompressed by a crash, are passed too. Rotation and retention wait
+	// for it, so a slow hook delays them but never blocks Write.
+	OnArchive func(path string) `json:"-" yaml:"-" toml:"-"`
+
+	size           int64
+	file           *os.File
+	nextRotate     time.Time
+	lastSpaceCheck time.Time
+	mu             sync.Mutex
+
+	millCh    chan bool
+	startMill sync.Once
+
+	rotatedMu sync.Mutex
+	rotated   []string // backups waiting for OnArchive
+
+	rotations  atomic.Int64
+	removed    atomic.Int64
+	compressed atomic.Int64
+	dropped    atomic.Int64
+}
+
+// Stats holds counters of the work a Logger has done since it was created.
+type Stats struct {
+	Rotations  int64 // files rotated, by size, time or Rotate
+	Removed    int64 // backups removed by the retention rules
+	Compressed int64 // backups compressed
+	Dropped    int64 // bytes dropped by OversizeTruncate
+}
+
+// Stats returns the Logger's counters.
+func (l *Logger) Stats() Stats {
+	return Stats{
+		Rotations:  l.rotations.Load(),
+		Removed:    l.removed.Load(),
+		Compressed: l.compressed.Load(),
+		Dropped:    l.dropped.Load(),
+	}
+}
+
+// Validate reports an unknown RotateEvery, Compression or Oversize, or a
+// CompressLevel the codec does not support. Write does not check them, so
+// callers taking them from configuration should.
+func (l *Logger) Validate() error {
+	switch l.RotateEvery {
+	case "", RotateHourly, RotateDaily:
+	default:
+		return fmt.Errorf("unknown rotate schedule: %s", l.RotateEvery)
+	}
+	c, err := l.codec()
+	if err != nil {
+		return err
+	}
+	if c != nil && l.CompressLevel != 0 && (l.CompressLevel < c.minLevel || l.CompressLevel > c.maxLevel) {
+		return fmt.Errorf("%s compression level %d out of range %d to %d", c.name, l.CompressLevel, c.minLevel, c.maxLevel)
+	}
+	switch l.Oversize {
+	case "", OversizeSplit, OversizeTruncate:
+	default:
+		return fmt.Errorf("unknown oversize mode: %s", l.Oversize)
+	}
+	return nil
+}
+
+var (
+	currentTime = time.Now
+
+	osStat = os.Stat
+
+	megabyte = 1024 * 1024
+)
+
+// Write implements io.Writer. A write larger than MaxSize is split across
+// rotations or truncated according to Oversize.
+func (l *Logger) Write(p []byte) (n int, err error) {
+	l.mu.Lock()
+	n, dropped, err := l.write(p)
+	l.mu.Unlock()
+
+	if dropped > 0 {
+		l.dropped.Add(int64(dropped))
+		if l.OnDrop != nil {
+			l.OnDrop(dropped)
+		}
+	}
+	return n, err
+}
+
+// write does the work of Write with l.mu held. It returns the bytes of p
+// consumed and, when truncating, how many of them were dropped.
+func (l *Logger) write(p []byte) (n, dropped int, err error) {
+	limit := l.max()
+	if int64(len(p)) > limit && l.Oversize == OversizeTruncate {
+		keep := int(limit) - len(truncatedMark)
+		dropped = len(p) - keep
+		// the full slice expression makes append copy instead of
+		// overwriting the caller's buffer.
+		q := append(p[:keep:keep], truncatedMark...)
+		if _, _, err := l.write(q); err != nil {
+			return 0, 0, err
+		}
+		return len(p), dropped, nil
+	}
+
+	if l.file == nil {
+		if err = l.openExistingOrNew(int(min(int64(len(p)), limit))); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	for len(p) > 0 {
+		chunk := p
+		if int64(len(chunk)) > limit {
+			chunk = chunk[:limit]
+			if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 {
+				chunk = chunk[:i+1]
+			}
+		}
+		if l.size+int64(len(chunk)) > limit || l.due() {
+			if err := l.rotate(); err != nil {
+				return n, 0, err
+			}
+		}
+		m, err := l.file.Write(chunk)
+		l.size += int64(m)
+		n += m
+		if err != nil {
+			return n, 0, err
+		}
+		p = p[len(chunk):]
+	}
+
+	if l.MinFreeSpace > 0 {
+		if now := currentTime(); now.Sub(l.lastSpaceCheck) >= spaceCheckInterval {
+			l.lastSpaceCheck = now
+			l.mill()
+		}
+	}
+
+	return n, 0, nil
+}
+
+// Close implements io.Closer, and closes the current logfile.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.close()
+}
+
+// close closes the file if it is open.
+func (l *Logger) close() error {
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// Rotate causes Logger to close the existing log file and immediately create a
+// new one.  This is a helper function for applications that want to initiate
+// rotations outside of the normal rotation rules, such as in response to
+// SIGHUP.  After rotating, this initiates compression and removal of old log
+// files according to the configuration.
+func (l *Logger) Rotate() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rotate()
+}
+
+// Reopen closes the current log file and opens it again by name without
+// renaming it. This is for coexisting with external tools such as logrotate:
+// after they have moved the file aside, Reopen starts a fresh file; after a
+// copytruncate it picks up the truncated size.
+func (l *Logger) Reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.close(); err != nil {
+		return err
+	}
+	return l.openExistingOrNew(0)
+}
+
+// rotate closes the current file, moves it aside with a timestamp in the name,
+// (if it exists), opens a new file with the original filename, and then runs
+// post-rotation processing and removal.
+func (l *Logger) rotate() error {
+	if err := l.close(); err != nil {
+		return err
+	}
+	if err := l.openNew(); err != nil {
+		return err
+	}
+	l.rotations.Add(1)
+	l.mill()
+	return nil
+}
+
+// openNew opens a new log file for writing, moving any old log file out of the
+// way.  This methods assumes the file has already been closed.
+func (l *Logger) openNew() error {
+	err := os.MkdirAll(l.dir(), 0755)
+	if err != nil {
+		return fmt.Errorf("can't make directories for new logfile: %s", err)
+	}
+	if err := os.MkdirAll(l.backupDir(), 0755); err != nil {
+		return fmt.Errorf("can't make backup directory: %s", err)
+	}
+
+	name := l.filename()
+	mode := os.FileMode(0600)
+	info, err := osStat(name)
+	if err == nil {
+		// Copy the mode off the old logfile.
+		mode = info.Mode()
+		// move the existing file
+		newname := backupName(l.backupDir(), name, l.LocalTime)
+		if err := os.Rename(name, newname); err != nil {
+			return fmt.Errorf("can't rename log file: %s", err)
+		}
+		if l.OnArchive != nil {
+			l.rotatedMu.Lock()
+			l.rotated = append(l.rotated, newname)
+			l.rotatedMu.Unlock()
+		}
+
+		// this is a no-op anywhere but linux
+		if err := chown(name, info); err != nil {
+			return err
+		}
+	}
+
+	// we use truncate here because this should only get called when we've moved
+	// the file ourselves. if someone else creates the file in the meantime,
+	// just wipe out the contents.
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return fmt.Errorf("can't open new logfile: %s", err)
+	}
+	l.file = f
+	l.size = 0
+	l.nextRotate = l.boundary(currentTime(), 1)
+	return nil
+}
+
+// backupName creates a new filename in dir from the given name, inserting a
+// timestamp between the filename and the extension, using the local time if
+// requested (otherwise UTC).
+func backupName(dir string, name string, local bool) string {
+	filename := filepath.Base(name)
+	ext := filepath.Ext(filename)
+	prefix := filename[:len(filename)-len(ext)]
+	t := currentTime()
+	if !local {
+		t = t.UTC()
+	}
+
+	// rotations within the same millisecond, e.g. while splitting an
+	// oversized write, would overwrite each other's backup; step the
+	// timestamp forward until the name is free.
+	for {
+		timestamp := t.Format(backupTimeFormat)
+		newname := filepath.Join(dir, fmt.Sprintf("%s-%s%s", prefix, timestamp, ext))
+		if !backupExists(newname) {
+			return newname
+		}
+		t = t.Add(time.Millisecond)
+	}
+}
+
+// backupExists reports whether the backup name exists, compressed or not.
+func backupExists(name string) bool {
+	if _, err := os.Lstat(name); !os.IsNotExist(err) {
+		return true
+	}
+	for _, c := range codecs {
+		if _, err := os.Lstat(name + c.suffix); !os.IsNotExist(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// openExistingOrNew opens the logfile if it exists and if the current write
+// would not put it over MaxSize.  If there is no such file or the write would
+// put it over the MaxSize, a new file is created.
+func (l *Logger) openExistingOrNew(writeLen int) error {
+	l.mill()
+
+	filename := l.filename()
+	info, err := osStat(filename)
+	if os.IsNotExist(err) {
+		return l.openNew()
+	}
+	if err != nil {
+		return fmt.Errorf("error getting log file info: %s", err)
+	}
+
+	if info.Size()+int64(writeLen) >= l.max() {
+		return l.rotate()
+	}
+	// the existing file was written in an earlier rotation period.
+	if l.RotateEvery != "" && info.ModTime().Before(l.boundary(currentTime(), 0)) {
+		return l.rotate()
+	}
+
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		// if we fail to open the old log file for some reason, just ignore
+		// it and open a new log file.
+		return l.openNew()
+	}
+	l.file = file
+	l.size = info.Size()
+	l.nextRotate = l.boundary(currentTime(), 1)
+	return nil
+}
+
+// due reports whether the current file has reached its time based rotation
+// boundary.
+func (l *Logger) due() bool {
+	return !l.nextRotate.IsZero() && !currentTime().Before(l.nextRotate)
+}
+
+// boundary returns the start of the RotateEvery period containing t, shifted
+// by n periods, in local time or UTC according to LocalTime. It returns the
+// zero time if time based rotation is disabled.
+func (l *Logger) boundary(t time.Time, n int) time.Time {
+	if !l.LocalTime {
+		t = t.UTC()
+	}
+	y, m, d := t.Date()
+	switch l.RotateEvery {
+	case RotateHourly:
+		return time.Date(y, m, d, t.Hour()+n, 0, 0, 0, t.Location())
+	case RotateDaily:
+		return time.Date(y, m, d+n, 0, 0, 0, 0, t.Location())
+	}
+	return time.Time{}
+}
+
+// filename generates the name of the logfile from the current time.
+func (l *Logger) filename() string {
+	if l.Filename != "" {
+		return l.Filename
+	}
+	name := filepath.Base(os.Args[0]) + "-lumberjack.log"
+	return filepath.Join(os.TempDir(), name)
+}
+
+// millRunOnce performs compression and removal of stale log files.
+// Log files are compressed if enabled via configuration and old log
+// files are removed, keeping at most l.MaxBackups files, as long as
+// none of them are older than MaxAge, and then removing the oldest
+// ones while over MaxTotalSize or under MinFreeSpace. Finished backups
+// are passed to OnArchive.
+func (l *Logger) millRunOnce() error {
+	l.rotatedMu.Lock()
+	rotated := l.rotated
+	l.rotated = nil
+	l.rotatedMu.Unlock()
+
+	codec, err := l.codec()
+	if err != nil {
+		return err
+	}
+	if codec == nil {
+		// without compression a backup is finished once it is rotated.
+		defer l.archive(rotated)
+	}
+	if l.MaxBackups == 0 && l.MaxAge == 0 && codec == nil &&
+		l.MaxTotalSize == 0 && l.MinFreeSpace == 0 && !l.Manifest {
+		return nil
+	}
+
+	files, err := l.oldLogFiles()
+	if err != nil {
+		return err
+	}
+
+	var compress, remove []logInfo
+
+	if l.MaxBackups > 0 && l.MaxBackups < len(files) {
+		preserved := make(map[string]bool)
+		var remaining []logInfo
+		for _, f := range files {
+			// Only count the uncompressed log file or the
+			// compressed log file, not both.
+			fn := trimCompressSuffix(f.Name())
+			preserved[fn] = true
+
+			if len(preserved) > l.MaxBackups {
+				remove = append(remove, f)
+			} else {
+				remaining = append(remaining, f)
+			}
+		}
+		files = remaining
+	}
+	if l.MaxAge > 0 {
+		diff := time.Duration(int64(24*time.Hour) * int64(l.MaxAge))
+		cutoff := currentTime().Add(-1 * diff)
+
+		var remaining []logInfo
+		for _, f := range files {
+			if f.timestamp.Before(cutoff) {
+				remove = append(remove, f)
+			} else {
+				remaining = append(remaining, f)
+			}
+		}
+		files = remaining
+	}
+
+	if l.MaxTotalSize > 0 || l.MinFreeSpace > 0 {
+		var pruned []logInfo
+		files, pruned = l.pruneBySpace(files)
+		remove = append(remove, pruned...)
+	}
+
+	if codec != nil {
+		for _, f := range files {
+			if codecFromName(f.Name()) == nil {
+				compress = append(compress, f)
+			}
+		}
+	}
+
+	// backups no longer in the manifest
+	gone := make(map[string]bool)
+	for _, f := range remove {
+		errRemove := os.Remove(filepath.Join(l.backupDir(), f.Name()))
+		if err == nil && errRemove != nil {
+			err = errRemove
+		}
+		if errRemove == nil {
+			l.removed.Add(1)
+			gone[f.Name()] = true
+		}
+	}
+	for _, f := range compress {
+		fn := filepath.Join(l.backupDir(), f.Name())
+		errCompress := compressLogFile(fn, fn+codec.suffix, codec, l.CompressLevel)
+		if err == nil && errCompress != nil {
+			err = errCompress
+		}
+		if errCompress == nil {
+			l.compressed.Add(1)
+			gone[f.Name()] = true
+		}
+	}
+	if l.Manifest {
+		// record the backups before handing them to OnArchive.
+		if errManifest := l.updateManifest(codec, gone); err == nil && errManifest != nil {
+			err = errManifest
+		}
+	}
+	if codec != nil {
+		for _, f := range compress {
+			if gone[f.Name()] {
+				l.archive([]string{filepath.Join(l.backupDir(), f.Name()+codec.suffix)})
+			}
+		}
+	}
+
+	return err
+}
+
+// archive passes the backups that still exist to OnArchive.
+func (l *Logger) archive(paths []string) {
+	if l.OnArchive == nil {
+		return
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			// already removed by the retention rules.
+			continue
+		}
+		l.OnArchive(path)
+	}
+}
+
+// pruneBySpace splits files, sorted newest first, into those to keep and
+// those to remove so that the current file plus the kept backups fit in
+// MaxTotalSize and removing them brings free space up to MinFreeSpace.
+// The oldest backups are removed first; the current file is never removed.
+func (l *Logger) pruneBySpace(files []logInfo) (keep, remove []logInfo) {
+	var total int64
+	if info, err := osStat(l.filename()); err == nil {
+		total = info.Size()
+	}
+	for _, f := range files {
+		total += f.Size()
+	}
+	maxTotal := int64(l.MaxTotalSize) * int64(megabyte)
+	minFree := int64(l.MinFreeSpace) * int64(megabyte)
+	var free int64
+	if minFree > 0 {
+		var err error
+		if free, err = diskFree(l.backupDir()); err != nil {
+			// can't tell, don't remove anything on account of free space.
+			minFree = 0
+		}
+	}
+
+	n := len(files)
+	for n > 0 && ((maxTotal > 0 && total > maxTotal) || (minFree > 0 && free < minFree)) {
+		n--
+		total -= files[n].Size()
+		free += files[n].Size()
+	}
+	return files[:n], files[n:]
+}
+
+// millRun runs in a goroutine to manage post-rotation compression and removal
+// of old log files.
+func (l *Logger) millRun() {
+	for range l.millCh {
+		// what am I going to do, log this?
+		_ = l.millRunOnce()
+	}
+}
+
+// mill performs post-rotation compression and removal of stale log files,
+// starting the mill goroutine if necessary.
+func (l *Logger) mill() {
+	l.startMill.Do(func() {
+		l.millCh = make(chan bool, 1)
+		go l.millRun()
+	})
+	select {
+	case l.millCh <- true:
+	default:
+	}
+}
+
+// oldLogFiles returns the list of backup log files stored in the backup
+// directory, sorted by the time in their names, newest first.
+func (l *Logger) oldLogFiles() ([]logInfo, error) {
+	files, err := os.ReadDir(l.backupDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("can't read backup directory: %s", err)
+	}
+	logFiles := make([]logInfo, 0, len(files))
+
+	prefix, ext := l.prefixAndExt()
+
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		fileInfo, err := f.Info()
+		if err != nil {
+			continue
+		}
+		if t, err := l.timeFromName(f.Name(), prefix, ext); err == nil {
+			logFiles = append(logFiles, logInfo{t, fileInfo})
+			continue
+		}
+		if c := codecFromName(f.Name()); c != nil {
+			if t, err := l.timeFromName(f.Name(), prefix, ext+c.suffix); err == nil {
+				logFiles = append(logFiles, logInfo{t, fileInfo})
+				continue
+			}
+		}
+		// error parsing means that the suffix at the end was not generated
+		// by lumberjack, and therefore it's not a backup file.
+	}
+
+	sort.Sort(byFormatTime(logFiles))
+
+	return logFiles, nil
+}
+
+// timeFromName extracts the formatted time from the filename by stripping off
+// the filename's prefix and extension. This prevents someone's filename from
+// confusing time.parse.
+func (l *Logger) timeFromName(filename, prefix, ext string) (time.Time, error) {
+	if !strings.HasPrefix(filename, prefix) {
+		return time.Time{}, errors.New("mismatched prefix")
+	}
+	if !strings.HasSuffix(filename, ext) {
+		return time.Time{}, errors.New("mismatched extension")
+	}
+	ts := filename[len(prefix) : len(filename)-len(ext)]
+	return time.Parse(backupTimeFormat, ts)
+}
+
+// max returns the maximum size in bytes of log files before rolling.
+func (l *Logger) max() int64 {
+	if l.MaxSize == 0 {
+		return int64(defaultMaxSize * megabyte)
+	}
+	return int64(l.MaxSize) * int64(megabyte)
+}
+
+// dir returns the directory for the current filename.
+func (l *Logger) dir() string {
+	return filepath.Dir(l.filename())
+}
+
+// backupDir returns the directory holding backups.
+func (l *Logger) backupDir() string {
+	if l.BackDir != "" {
+		return l.BackDir
+	}
+	return l.dir()
+}
+
+// prefixAndExt returns the filename part and extension part from the Logger's
+// filename.
+func (l *Logger) prefixAndExt() (prefix, ext string) {
+	filename := filepath.Base(l.filename())
+	ext = filepath.Ext(filename)
+	prefix = filename[:len(filename)-len(ext)] + "-"
+	return prefix, ext
+}
+
+// compressLogFile compresses the given log file with codec at level,
+// removing the uncompressed log file if successful.
+func compressLogFile(src, dst string, codec *codec, level int) (err error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	defer f.Close()
+
+	fi, err := osStat(src)
+	if err != nil {
+		return fmt.Errorf("failed to stat log file: %v", err)
+	}
+
+	if err := chown(dst, fi); err != nil {
+		return fmt.Errorf("failed to chown compressed log file: %v", err)
+	}
+
+	// If this file already exists, we presume it was created by
+	// a previous attempt to compress the log file.
+	gzf, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fi.Mode())
+	if err != nil {
+		return fmt.Errorf("failed to open compressed log file: %v", err)
+	}
+	defer gzf.Close()
+
+	defer func() {
+		if err != nil {
+			os.Remove(dst)
+			err = fmt.Errorf("failed to compress log file: %v", err)
+		}
+	}()
+
+	gz, err := codec.newWriter(gzf, level)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 128*1024)
+	if _, err := io.CopyBuffer(gz, f, buf); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := gzf.Close(); err != nil {
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Remove(src); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// logInfo is a convenience struct to return the filename and its embedded
+// timestamp.
+type logInfo struct {
+	timestamp time.Time
+	os.FileInfo
+}
+
+// byFormatTime sorts by newest time formatted in the name.
+type byFormatTime []logInfo
+
+func (b byFormatTime) Less(i, j int) bool {
+	return b[i].timestamp.After(b[j].timestamp)
+}
+
+func (b byFormatTime) Swap(i, j int) {
+	b[i], b[j] = b[j], b[i]
+}
+
+func (b byFormatTime) Len() int {
+	return len(b)
+}
@@ -0,0 +1,360 @@
+package logrotate
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startTime is where the fake clock starts in every test.
+var startTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// fakeCurrentTime is returned by currentTime during the tests, so they control
+// backup names and MaxAge. The mill goroutines read it too, hence the lock.
+var (
+	fakeMu          sync.Mutex
+	fakeCurrentTime = startTime
+)
+
+func fakeTime() time.Time {
+	fakeMu.Lock()
+	defer fakeMu.Unlock()
+	return fakeCurrentTime
+}
+
+// TestMain uses the fake clock and counts sizes in bytes instead of
+// megabytes. They are set once because mill goroutines outlive their test.
+func TestMain(m *testing.M) {
+	currentTime = fakeTime
+	megabyte = 1
+	os.Exit(m.Run())
+}
+
+// useFakeTime resets the fake clock to startTime.
+func useFakeTime(t *testing.T) {
+	t.Helper()
+	fakeMu.Lock()
+	defer fakeMu.Unlock()
+	fakeCurrentTime = startTime
+}
+
+// advance moves the fake clock forward.
+func advance(d time.Duration) {
+	fakeMu.Lock()
+	defer fakeMu.Unlock()
+	fakeCurrentTime = fakeCurrentTime.Add(d)
+}
+
+// eventually waits for the mill goroutine to make cond true.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// names lists the files in dir, sorted.
+func names(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, e := range entries {
+		out = append(out, e.Name())
+	}
+	sort.Strings(out)
+	return out
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func mustWrite(t *testing.T, l *Logger, s string) {
+	t.Helper()
+	n, err := l.Write([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(s) {
+		t.Fatalf("wrote %d bytes, want %d", n, len(s))
+	}
+}
+
+// backupContents returns the contents of l's backups, oldest first.
+func backupContents(t *testing.T, l *Logger) []string {
+	t.Helper()
+	backups, err := l.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, b := range backups {
+		r, err := OpenBackup(b.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, string(data))
+	}
+	return out
+}
+
+func TestOversizeSplit(t *testing.T) {
+	tests := []struct {
+		name    string
+		write   string
+		current string
+		backups []string
+	}{
+		{"at newline", "aaaa\nbbbb\ncccc\n", "cccc\n", []string{"aaaa\nbbbb\n"}},
+		{"before last newline", "aaa\nbbbbbbbb\n", "bbbbbbbb\n", []string{"aaa\n"}},
+		{"no newline", "0123456789abcdefghijklmn", "klmn", []string{"0123456789", "abcdefghij"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeTime(t)
+			dir := t.TempDir()
+			l := New(filepath.Join(dir, "app.log"), Options().SetMaxSize(10))
+			defer l.Close()
+			mustWrite(t, l, tt.write)
+			if got := readFile(t, l.Filename); got != tt.current {
+				t.Errorf("current file = %q, want %q", got, tt.current)
+			}
+			got := backupContents(t, l)
+			if strings.Join(got, "|") != strings.Join(tt.backups, "|") {
+				t.Errorf("backups = %q, want %q", got, tt.backups)
+			}
+			if r := l.Stats().Rotations; r != int64(len(tt.backups)) {
+				t.Errorf("rotations = %d, want %d", r, len(tt.backups))
+			}
+		})
+	}
+}
+
+func TestOversizeTruncate(t *testing.T) {
+	useFakeTime(t)
+	dir := t.TempDir()
+	var dropped int
+	l := New(filepath.Join(dir, "app.log"), Options().
+		SetMaxSize(40).
+		SetOversize(OversizeTruncate).
+		SetOnDrop(func(n int) { dropped += n }))
+	defer l.Close()
+
+	mustWrite(t, l, "short\n")
+	big := strings.Repeat("x", 100)
+	mustWrite(t, l, big)
+
+	keep := 40 - len(truncatedMark)
+	if got, want := readFile(t, l.Filename), big[:keep]+truncatedMark; got != want {
+		t.Errorf("current file = %q, want %q", got, want)
+	}
+	if got := backupContents(t, l); len(got) != 1 || got[0] != "short\n" {
+		t.Errorf("backups = %q, want the earlier write", got)
+	}
+	if dropped != 100-keep {
+		t.Errorf("OnDrop got %d bytes, want %d", dropped, 100-keep)
+	}
+	if d := l.Stats().Dropped; d != int64(100-keep) {
+		t.Errorf("Stats().Dropped = %d, want %d", d, 100-keep)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		l       *Logger
+		wantErr bool
+	}{
+		{&Logger{}, false},
+		{&Logger{RotateEvery: RotateDaily, Compression: CompressZstd, Oversize: OversizeTruncate}, false},
+		{&Logger{RotateEvery: "weekly"}, true},
+		{&Logger{Compression: "lz4"}, true},
+		{&Logger{Oversize: "drop"}, true},
+		{&Logger{Compress: true, CompressLevel: 9}, false},
+		{&Logger{Compress: true, CompressLevel: -2}, false},
+		{&Logger{Compress: true, CompressLevel: 15}, true},
+		{&Logger{Compression: CompressGzip, CompressLevel: -3}, true},
+		{&Logger{Compression: CompressZstd, CompressLevel: 1}, false},
+		{&Logger{Compression: CompressZstd, CompressLevel: 22}, false},
+		{&Logger{Compression: CompressZstd, CompressLevel: 23}, true},
+		{&Logger{Compression: CompressZstd, CompressLevel: -1}, true},
+		// the level is ignored when backups are not compressed.
+		{&Logger{CompressLevel: 15}, false},
+		{&Logger{Compression: CompressNone, CompressLevel: 15}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.l.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%q, %q, %q, level %d) = %v, want error %v",
+				tt.l.RotateEvery, tt.l.Compression, tt.l.Oversize, tt.l.CompressLevel, err, tt.wantErr)
+		}
+	}
+}
+
+// backDirLogger returns a Logger writing dir/cur/app.log with backups in
+// dir/back.
+func backDirLogger(t *testing.T, opts *Option) (l *Logger, cur, back string) {
+	t.Helper()
+	dir := t.TempDir()
+	cur, back = filepath.Join(dir, "cur"), filepath.Join(dir, "back")
+	l = New(filepath.Join(cur, "app.log"), Options().SetBackDir(back), opts)
+	t.Cleanup(func() { l.Close() })
+	return l, cur, back
+}
+
+func TestBackDirRotation(t *testing.T) {
+	useFakeTime(t)
+	l, cur, back := backDirLogger(t, Options().SetMaxSize(10))
+	for _, s := range []string{"first....\n", "second...\n", "third....\n"} {
+		mustWrite(t, l, s)
+		advance(time.Second)
+	}
+
+	if got := names(t, cur); len(got) != 1 || got[0] != "app.log" {
+		t.Errorf("log dir holds %v, want only app.log", got)
+	}
+	// backups are named after the time they were rotated.
+	want := []string{"app-2024-01-02T03-04-06.000.log", "app-2024-01-02T03-04-07.000.log"}
+	if got := names(t, back); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("backup dir holds %v, want %v", got, want)
+	}
+	backups, err := l.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, b := range backups {
+		if b.Path != filepath.Join(back, want[i]) {
+			t.Errorf("backup %d path = %s, want it in %s", i, b.Path, back)
+		}
+	}
+	if got := backupContents(t, l); strings.Join(got, "") != "first....\nsecond...\n" {
+		t.Errorf("backups = %q", got)
+	}
+}
+
+func TestBackDirCompression(t *testing.T) {
+	useFakeTime(t)
+	l, cur, back := backDirLogger(t, Options().SetMaxSize(10).SetCompression(CompressGzip))
+	mustWrite(t, l, "first....\n")
+	advance(time.Second)
+	mustWrite(t, l, "second...\n")
+
+	want := "app-2024-01-02T03-04-06.000.log.gz"
+	eventually(t, "compression", func() bool {
+		got := names(t, back)
+		return len(got) == 1 && got[0] == want
+	})
+	if got := names(t, cur); len(got) != 1 || got[0] != "app.log" {
+		t.Errorf("log dir holds %v, want only app.log", got)
+	}
+	backups, err := l.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || !backups[0].Compressed || backups[0].Path != filepath.Join(back, want) {
+		t.Fatalf("Backups() = %+v", backups)
+	}
+	if got := backupContents(t, l); len(got) != 1 || got[0] != "first....\n" {
+		t.Errorf("backups = %q", got)
+	}
+	if c := l.Stats().Compressed; c != 1 {
+		t.Errorf("compressed = %d, want 1", c)
+	}
+}
+
+func TestBackDirMaxBackups(t *testing.T) {
+	useFakeTime(t)
+	l, cur, back := backDirLogger(t, Options().SetMaxSize(10).SetMaxBackups(2))
+	// a file in the log dir that looks like a backup is not one of ours.
+	stray := filepath.Join(cur, "app-2020-01-01T00-00-00.000.log")
+	if err := os.MkdirAll(cur, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(stray, []byte("stray\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		mustWrite(t, l, "line.....\n")
+		advance(time.Second)
+	}
+
+	want := []string{"app-2024-01-02T03-04-08.000.log", "app-2024-01-02T03-04-09.000.log"}
+	eventually(t, "pruning", func() bool {
+		return strings.Join(names(t, back), " ") == strings.Join(want, " ")
+	})
+	if got := names(t, cur); strings.Join(got, " ") != filepath.Base(stray)+" app.log" {
+		t.Errorf("log dir holds %v, want the stray file and app.log", got)
+	}
+	backups, err := l.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Errorf("Backups() lists %d files, want 2", len(backups))
+	}
+	eventually(t, "removed count", func() bool { return l.Stats().Removed == 2 })
+}
+
+func TestBackDirMaxAge(t *testing.T) {
+	useFakeTime(t)
+	l, _, back := backDirLogger(t, Options().SetMaxAge(2))
+	mustWrite(t, l, "old\n")
+	if err := l.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	old := "app-2024-01-02T03-04-05.000.log"
+	eventually(t, "first backup", func() bool {
+		got := names(t, back)
+		return len(got) == 1 && got[0] == old
+	})
+
+	advance(3 * 24 * time.Hour)
+	mustWrite(t, l, "new\n")
+	if err := l.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	want := "app-2024-01-05T03-04-05.000.log"
+	eventually(t, "expired backup removed", func() bool {
+		got := names(t, back)
+		return len(got) == 1 && got[0] == want
+	})
+	if got := backupContents(t, l); len(got) != 1 || got[0] != "new\n" {
+		t.Errorf("backups = %q", got)
+	}
+}
+
+func TestBackupNameUnique(t *testing.T) {
+	useFakeTime(t)
+	dir := t.TempDir()
+	first := backupName(dir, "app.log", false)
+	if err := os.WriteFile(first+".zst", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	second := backupName(dir, "app.log", false)
+	if first == second {
+		t.Fatalf("backupName returned %s twice", first)
+	}
+	if !strings.HasSuffix(second, "03-04-05.001.log") {
+		t.Errorf("second name = %s, want the timestamp stepped by 1ms", second)
+	}
+}
@@ -0,0 +1,205 @@
+package logrotate
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ManifestEntry describes one finished backup in the manifest.
+type ManifestEntry struct {
+	Name string `json:"name"`
+	// From is when the backup was started, the time of the rotation
+	// before it; zero if that is unknown. To is when it was rotated.
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// Size and SHA256 are of the file as stored, compressed or not.
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// Lines counts the newlines in the uncompressed content.
+	Lines int64 `json:"lines"`
+}
+
+// manifest is the file the mill keeps in the backup directory.
+type manifest struct {
+	Filename string          `json:"filename"`
+	Files    []ManifestEntry `json:"files"`
+}
+
+// ManifestName returns the path of the manifest, <name>.manifest.json in
+// the backup directory.
+func (l *Logger) ManifestName() string {
+	prefix, _ := l.prefixAndExt()
+	return filepath.Join(l.backupDir(), prefix[:len(prefix)-1]+".manifest.json")
+}
+
+// ReadManifest returns the manifest entries, oldest first.
+func (l *Logger) ReadManifest() ([]ManifestEntry, error) {
+	data, err := os.ReadFile(l.ManifestName())
+	if err != nil {
+		return nil, err
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("bad manifest %s: %v", l.ManifestName(), err)
+	}
+	return m.Files, nil
+}
+
+// updateManifest drops the entries of the backups in gone, which the mill
+// removed or compressed, and adds the finished backups not listed yet.
+// With a codec only compressed backups are finished.
+func (l *Logger) updateManifest(codec *codec, gone map[string]bool) error {
+	entries, err := l.ReadManifest()
+	if err != nil && !os.IsNotExist(err) {
+		// start over rather than stop recording new backups.
+		entries = nil
+	}
+	known := make(map[string]bool)
+	kept := entries[:0]
+	for _, e := range entries {
+		if !gone[e.Name] {
+			kept = append(kept, e)
+			known[e.Name] = true
+		}
+	}
+	entries = kept
+
+	files, err := l.oldLogFiles()
+	if err != nil {
+		return err
+	}
+	changed := len(gone) > 0
+	for i, f := range files {
+		if known[f.Name()] || (codec != nil && codecFromName(f.Name()) == nil) {
+			continue
+		}
+		e := ManifestEntry{Name: f.Name(), To: l.localize(f.timestamp)}
+		if i+1 < len(files) {
+			e.From = l.localize(files[i+1].timestamp)
+		}
+		if e.Size, e.SHA256, e.Lines, err = checksum(filepath.Join(l.backupDir(), f.Name())); err != nil {
+			return err
+		}
+		entries = append(entries, e)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].To.Before(entries[j].To) })
+
+	data, err := json.MarshalIndent(manifest{Filename: filepath.Base(l.filename()), Files: entries}, "", "  ")
+	if err != nil {
+		return err
+	}
+	// write a temporary file and rename it so readers never see half a
+	// manifest.
+	name := l.ManifestName()
+	if err := os.WriteFile(name+".tmp", append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("can't write manifest: %s", err)
+	}
+	return os.Rename(name+".tmp", name)
+}
+
+// localize moves a time parsed from a backup name, which is UTC, into the
+// local time zone if the names are written in local time.
+func (l *Logger) localize(t time.Time) time.Time {
+	if !l.LocalTime {
+		return t
+	}
+	y, m, d := t.Date()
+	return time.Date(y, m, d, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+}
+
+// checksum returns the size and SHA-256 of the file at path and the number
+// of lines in its content, decompressing it if its name has a codec suffix.
+// A truncated or corrupt compressed file is an error.
+func checksum(path string) (size int64, sum string, lines int64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	raw := io.TeeReader(f, h)
+	content := raw
+	if c := codecFromName(path); c != nil {
+		r, err := c.newReader(raw)
+		if err != nil {
+			return 0, "", 0, err
+		}
+		defer r.Close()
+		content = r
+	}
+	buf := make([]byte, 128*1024)
+	for {
+		n, err := content.Read(buf)
+		lines += int64(bytes.Count(buf[:n], []byte{'\n'}))
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, "", 0, err
+		}
+	}
+	// the decompressor may stop before the end of the file.
+	if _, err := io.CopyBuffer(io.Discard, raw, buf); err != nil {
+		return 0, "", 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return 0, "", 0, err
+	}
+	return info.Size(), hex.EncodeToString(h.Sum(nil)), lines, nil
+}
+
+// VerifyResult is the outcome of checking one manifest entry.
+type VerifyResult struct {
+	ManifestEntry
+	Err error // nil if the backup matches its entry
+}
+
+// ErrNoManifest is returned by Verify when the backup directory has no
+// manifest, e.g. because Manifest was never enabled.
+var ErrNoManifest = errors.New("no manifest")
+
+// Verify checks every backup listed in the manifest: it must exist, have
+// the recorded size and SHA-256, decompress cleanly and have the recorded
+// number of lines.
+func (l *Logger) Verify() ([]VerifyResult, error) {
+	entries, err := l.ReadManifest()
+	if os.IsNotExist(err) {
+		return nil, ErrNoManifest
+	}
+	if err != nil {
+		return nil, err
+	}
+	results := make([]VerifyResult, len(entries))
+	for i, e := range entries {
+		results[i].ManifestEntry = e
+		size, sum, lines, err := checksum(filepath.Join(l.backupDir(), e.Name))
+		switch {
+		case os.IsNotExist(err):
+			results[i].Err = errors.New("missing")
+		case err != nil:
+			results[i].Err = err
+		case size != e.Size:
+			results[i].Err = fmt.Errorf("size %d, manifest %d", size, e.Size)
+		case sum != e.SHA256:
+			results[i].Err = errors.New("sha256 mismatch")
+		case lines != e.Lines:
+			results[i].Err = fmt.Errorf("%d lines, manifest %d", lines, e.Lines)
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,222 @@
+package logrotate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// manifestLogger returns a Logger with a manifest, backups in dir/back and
+// the given backups written there uncompressed. Nothing is written through
+// the Logger, so the tests run the mill themselves.
+func manifestLogger(t *testing.T, opts *Option, backups map[string]string) (*Logger, string) {
+	t.Helper()
+	dir := t.TempDir()
+	back := filepath.Join(dir, "back")
+	l := New(filepath.Join(dir, "app.log"), Options().SetBackDir(back).SetManifest(true), opts)
+	if err := os.MkdirAll(back, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range backups {
+		if err := os.WriteFile(filepath.Join(back, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return l, back
+}
+
+func readManifest(t *testing.T, l *Logger) []ManifestEntry {
+	t.Helper()
+	entries, err := l.ReadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func entryNames(entries []ManifestEntry) string {
+	var s []string
+	for _, e := range entries {
+		s = append(s, e.Name)
+	}
+	return strings.Join(s, " ")
+}
+
+// backupTime is the time in the name of the backups these tests create.
+func backupTime(sec int) time.Time {
+	return time.Date(2024, 1, 2, 3, 4, sec, 0, time.UTC)
+}
+
+func TestManifestEntries(t *testing.T) {
+	l, back := manifestLogger(t, Options().SetCompression(CompressGzip), map[string]string{
+		"app-2024-01-02T03-04-05.000.log": "a\nb\n",
+		"app-2024-01-02T03-04-06.000.log": "c\n",
+		"app-2024-01-02T03-04-07.000.log": "d\ne\nf\n",
+	})
+	if err := l.millRunOnce(); err != nil {
+		t.Fatal(err)
+	}
+	entries := readManifest(t, l)
+	want := "app-2024-01-02T03-04-05.000.log.gz app-2024-01-02T03-04-06.000.log.gz app-2024-01-02T03-04-07.000.log.gz"
+	if got := entryNames(entries); got != want {
+		t.Fatalf("manifest lists %s, want %s", got, want)
+	}
+	for i, e := range entries {
+		// each backup starts where the one before it was rotated.
+		var from time.Time
+		if i > 0 {
+			from = backupTime(4 + i)
+		}
+		if !e.From.Equal(from) || !e.To.Equal(backupTime(5+i)) {
+			t.Errorf("%s: from %v to %v, want from %v to %v", e.Name, e.From, e.To, from, backupTime(5+i))
+		}
+		data, err := os.ReadFile(filepath.Join(back, e.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(data)
+		if e.Size != int64(len(data)) || e.SHA256 != hex.EncodeToString(sum[:]) {
+			t.Errorf("%s: size %d, sha256 %s, want the compressed file's %d, %x", e.Name, e.Size, e.SHA256, len(data), sum)
+		}
+		if wantLines := []int64{2, 1, 3}[i]; e.Lines != wantLines {
+			t.Errorf("%s: %d lines, want %d", e.Name, e.Lines, wantLines)
+		}
+	}
+
+	// an uncompressed backup is not finished yet and stays out of the
+	// manifest until the mill compresses it.
+	next := "app-2024-01-02T03-04-08.000.log"
+	if err := os.WriteFile(filepath.Join(back, next), []byte("g\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.updateManifest(codecs[CompressGzip], nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := entryNames(readManifest(t, l)); got != want {
+		t.Errorf("manifest lists %s before compression, want %s", got, want)
+	}
+	if err := l.millRunOnce(); err != nil {
+		t.Fatal(err)
+	}
+	entries = readManifest(t, l)
+	if len(entries) != 4 {
+		t.Fatalf("manifest lists %s after compression", entryNames(entries))
+	}
+	last := entries[3]
+	if last.Name != next+".gz" || !last.From.Equal(backupTime(7)) || !last.To.Equal(backupTime(8)) || last.Lines != 1 {
+		t.Errorf("new entry = %+v", last)
+	}
+}
+
+func TestManifestRetention(t *testing.T) {
+	l, back := manifestLogger(t, Options().SetMaxBackups(2), map[string]string{
+		"app-2024-01-02T03-04-05.000.log": "a\n",
+		"app-2024-01-02T03-04-06.000.log": "b\n",
+	})
+	if err := l.millRunOnce(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(back, "app-2024-01-02T03-04-07.000.log"), []byte("c\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.millRunOnce(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := readManifest(t, l)
+	want := "app-2024-01-02T03-04-06.000.log app-2024-01-02T03-04-07.000.log"
+	if got := entryNames(entries); got != want {
+		t.Fatalf("manifest lists %s, want %s", got, want)
+	}
+	if got := strings.Join(names(t, back), " "); got != want+" app.manifest.json" {
+		t.Errorf("backup dir holds %s", got)
+	}
+	// the kept entry still starts at the removed backup's rotation.
+	if !entries[0].From.Equal(backupTime(5)) {
+		t.Errorf("%s: from %v, want %v", entries[0].Name, entries[0].From, backupTime(5))
+	}
+	results, err := l.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if r.Err != nil {
+			t.Errorf("%s: %v", r.Name, r.Err)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	l, back := manifestLogger(t, nil, map[string]string{
+		"app-2024-01-02T03-04-05.000.log": "missing\n",
+		"app-2024-01-02T03-04-06.000.log": "truncated\n",
+		"app-2024-01-02T03-04-07.000.log": "altered\n",
+		"app-2024-01-02T03-04-08.000.log": "intact\n",
+	})
+	if _, err := l.Verify(); !errors.Is(err, ErrNoManifest) {
+		t.Fatalf("Verify() without a manifest = %v, want ErrNoManifest", err)
+	}
+	if err := l.millRunOnce(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := func(sec int) string {
+		return filepath.Join(back, backupTime(sec).Format("app-"+backupTimeFormat+".log"))
+	}
+	if err := os.Remove(path(5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path(6), 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path(7), []byte("ALTERED\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := l.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"missing", "size 4, manifest 10", "sha256 mismatch", ""}
+	if len(results) != len(want) {
+		t.Fatalf("Verify() checked %d backups, want %d", len(results), len(want))
+	}
+	for i, r := range results {
+		got := ""
+		if r.Err != nil {
+			got = r.Err.Error()
+		}
+		if got != want[i] {
+			t.Errorf("%s: %q, want %q", r.Name, got, want[i])
+		}
+	}
+}
+
+func TestVerifyTruncatedArchive(t *testing.T) {
+	l, back := manifestLogger(t, Options().SetCompression(CompressZstd), map[string]string{
+		"app-2024-01-02T03-04-05.000.log": strings.Repeat("a line of the log\n", 1000),
+	})
+	if err := l.millRunOnce(); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(back, "app-2024-01-02T03-04-05.000.log.zst")
+	info, err := os.Stat(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(archive, info.Size()/2); err != nil {
+		t.Fatal(err)
+	}
+	results, err := l.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Err == nil {
+		t.Errorf("Verify() = %+v, want an error for the truncated archive", results)
+	}
+}
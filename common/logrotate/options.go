@@ -0,0 +1,185 @@
+package logrotate
+
+// Option configures a Logger built by New. Create one with Options and
+// chain the setters; fields left unset keep the Logger's zero value.
+//
+//	logrotate.New("app.log", logrotate.Options().SetMaxSize(50).SetMaxBackups(10))
+type Option struct {
+	BackDir       *string
+	MaxSize       *int
+	MaxAge        *int
+	MaxBackups    *int
+	LocalTime     *bool
+	Compression   *string
+	CompressLevel *int
+	RotateEvery   *string
+	MaxTotalSize  *int
+	MinFreeSpace  *int
+	Oversize      *string
+	Manifest      *bool
+	OnDrop        func(dropped int)
+	OnArchive     func(path string)
+}
+
+func Options() *Option {
+	return &Option{}
+}
+
+func (o *Option) SetBackDir(dir string) *Option {
+	o.BackDir = &dir
+	return o
+}
+
+// SetMaxSize sets the size in megabytes at which the file is rotated.
+func (o *Option) SetMaxSize(megabytes int) *Option {
+	o.MaxSize = &megabytes
+	return o
+}
+
+// SetMaxAge sets the days backups are kept.
+func (o *Option) SetMaxAge(days int) *Option {
+	o.MaxAge = &days
+	return o
+}
+
+func (o *Option) SetMaxBackups(n int) *Option {
+	o.MaxBackups = &n
+	return o
+}
+
+func (o *Option) SetLocalTime(local bool) *Option {
+	o.LocalTime = &local
+	return o
+}
+
+// SetCompression sets the backup codec: CompressGzip, CompressZstd or
+// CompressNone.
+func (o *Option) SetCompression(codec string) *Option {
+	o.Compression = &codec
+	return o
+}
+
+func (o *Option) SetCompressLevel(level int) *Option {
+	o.CompressLevel = &level
+	return o
+}
+
+// SetRotateEvery sets time based rotation: RotateHourly or RotateDaily.
+func (o *Option) SetRotateEvery(every string) *Option {
+	o.RotateEvery = &every
+	return o
+}
+
+func (o *Option) SetMaxTotalSize(megabytes int) *Option {
+	o.MaxTotalSize = &megabytes
+	return o
+}
+
+func (o *Option) SetMinFreeSpace(megabytes int) *Option {
+	o.MinFreeSpace = &megabytes
+	return o
+}
+
+// SetOversize sets what happens to writes larger than MaxSize:
+// OversizeSplit or OversizeTruncate.
+func (o *Option) SetOversize(mode string) *Option {
+	o.Oversize = &mode
+	return o
+}
+
+// SetManifest keeps a manifest of backups with their checksums, see
+// Logger.Verify.
+func (o *Option) SetManifest(on bool) *Option {
+	o.Manifest = &on
+	return o
+}
+
+func (o *Option) SetOnDrop(fn func(dropped int)) *Option {
+	o.OnDrop = fn
+	return o
+}
+
+// SetOnArchive sets a callback run with the path of each finished backup,
+// e.g. to upload it.
+func (o *Option) SetOnArchive(fn func(path string)) *Option {
+	o.OnArchive = fn
+	return o
+}
+
+// merge copies the fields set in delta into o.
+func (o *Option) merge(delta *Option) {
+	if delta == nil {
+		return
+	}
+	if delta.BackDir != nil {
+		o.BackDir = delta.BackDir
+	}
+	if delta.MaxSize != nil {
+		o.MaxSize = delta.MaxSize
+	}
+	if delta.MaxAge != nil {
+		o.MaxAge = delta.MaxAge
+	}
+	if delta.MaxBackups != nil {
+		o.MaxBackups = delta.MaxBackups
+	}
+	if delta.LocalTime != nil {
+		o.LocalTime = delta.LocalTime
+	}
+	if delta.Compression != nil {
+		o.Compression = delta.Compression
+	}
+	if delta.CompressLevel != nil {
+		o.CompressLevel = delta.CompressLevel
+	}
+	if delta.RotateEvery != nil {
+		o.RotateEvery = delta.RotateEvery
+	}
+	if delta.MaxTotalSize != nil {
+		o.MaxTotalSize = delta.MaxTotalSize
+	}
+	if delta.MinFreeSpace != nil {
+		o.MinFreeSpace = delta.MinFreeSpace
+	}
+	if delta.Oversize != nil {
+		o.Oversize = delta.Oversize
+	}
+	if delta.Manifest != nil {
+		o.Manifest = delta.Manifest
+	}
+	if delta.OnDrop != nil {
+		o.OnDrop = delta.OnDrop
+	}
+	if delta.OnArchive != nil {
+		o.OnArchive = delta.OnArchive
+	}
+}
+
+// New returns a Logger writing to filename, configured by opts; later
+// options override earlier ones. The file is opened on the first Write.
+func New(filename string, opts ...*Option) *Logger {
+	o := Options()
+	for _, opt := range opts {
+		o.merge(opt)
+	}
+	l := &Logger{Filename: filename, OnDrop: o.OnDrop, OnArchive: o.OnArchive}
+	set(&l.BackDir, o.BackDir)
+	set(&l.MaxSize, o.MaxSize)
+	set(&l.MaxAge, o.MaxAge)
+	set(&l.MaxBackups, o.MaxBackups)
+	set(&l.LocalTime, o.LocalTime)
+	set(&l.Compression, o.Compression)
+	set(&l.CompressLevel, o.CompressLevel)
+	set(&l.RotateEvery, o.RotateEvery)
+	set(&l.MaxTotalSize, o.MaxTotalSize)
+	set(&l.MinFreeSpace, o.MinFreeSpace)
+	set(&l.Oversize, o.Oversize)
+	set(&l.Manifest, o.Manifest)
+	return l
+}
+
+func set[T any](dst *T, v *T) {
+	if v != nil {
+		*dst = *v
+	}
+}
@@ -0,0 +1,84 @@
+package logrotate
+
+import "testing"
+
+func TestNewOptions(t *testing.T) {
+	var dropped, archived bool
+	l := New("app.log",
+		Options().SetBackDir("back").SetMaxSize(5).SetMaxBackups(3),
+		nil,
+		Options().
+			SetMaxSize(7).
+			SetMaxAge(2).
+			SetLocalTime(true).
+			SetCompression(CompressZstd).
+			SetCompressLevel(3).
+			SetRotateEvery(RotateDaily).
+			SetMaxTotalSize(100).
+			SetMinFreeSpace(50).
+			SetOversize(OversizeTruncate).
+			SetManifest(true).
+			SetOnDrop(func(int) { dropped = true }).
+			SetOnArchive(func(string) { archived = true }),
+	)
+	// settings holds the comparable fields of a Logger.
+	type settings struct {
+		Filename, BackDir, Compression, RotateEvery, Oversize         string
+		MaxSize, MaxAge, MaxBackups, CompressLevel, MaxTotal, MinFree int
+		LocalTime, Manifest                                           bool
+	}
+	want := settings{
+		Filename:      "app.log",
+		BackDir:       "back",
+		MaxSize:       7, // later options win
+		MaxAge:        2,
+		MaxBackups:    3,
+		LocalTime:     true,
+		Compression:   CompressZstd,
+		CompressLevel: 3,
+		RotateEvery:   RotateDaily,
+		MaxTotal:      100,
+		MinFree:       50,
+		Oversize:      OversizeTruncate,
+		Manifest:      true,
+	}
+	got := settings{
+		Filename:      l.Filename,
+		BackDir:       l.BackDir,
+		MaxSize:       l.MaxSize,
+		MaxAge:        l.MaxAge,
+		MaxBackups:    l.MaxBackups,
+		LocalTime:     l.LocalTime,
+		Compression:   l.Compression,
+		CompressLevel: l.CompressLevel,
+		RotateEvery:   l.RotateEvery,
+		MaxTotal:      l.MaxTotalSize,
+		MinFree:       l.MinFreeSpace,
+		Oversize:      l.Oversize,
+		Manifest:      l.Manifest,
+	}
+	if got != want {
+		t.Errorf("New() = %+v, want %+v", got, want)
+	}
+	if l.OnDrop == nil || l.OnArchive == nil {
+		t.Fatal("callbacks not set")
+	}
+	l.OnDrop(1)
+	l.OnArchive("x")
+	if !dropped || !archived {
+		t.Error("callbacks are not the ones passed in")
+	}
+}
+
+func TestNewZeroOptions(t *testing.T) {
+	l := New("app.log")
+	if l.Filename != "app.log" || l.BackDir != "" || l.MaxSize != 0 || l.Compression != "" ||
+		l.OnDrop != nil || l.OnArchive != nil {
+		t.Errorf("New without options = %+v, want zero values", l)
+	}
+	// a later option that leaves a field unset does not clear it.
+	l = New("app.log", Options().SetMaxBackups(4), Options().SetMaxAge(1))
+	if l.MaxBackups != 4 || l.MaxAge != 1 {
+		t.Errorf("MaxBackups = %d, MaxAge = %d, want 4 and 1", l.MaxBackups, l.MaxAge)
+	}
+}
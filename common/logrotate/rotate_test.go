@@ -0,0 +1,180 @@
+package logrotate
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setTime moves the fake clock to tm.
+func setTime(tm time.Time) {
+	fakeMu.Lock()
+	defer fakeMu.Unlock()
+	fakeCurrentTime = tm
+}
+
+func TestBoundary(t *testing.T) {
+	east8 := time.FixedZone("UTC+8", 8*3600)
+	india := time.FixedZone("UTC+5:30", 5*3600+1800)
+	tests := []struct {
+		every     string
+		local     bool
+		t         time.Time
+		cur, next time.Time
+	}{
+		{RotateHourly, false, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC), time.Date(2024, 1, 2, 4, 0, 0, 0, time.UTC)},
+		{RotateHourly, false, time.Date(2024, 12, 31, 23, 59, 59, 0, time.UTC),
+			time.Date(2024, 12, 31, 23, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		// the hour starts at :30 in UTC+5:30 local time but at :00 in UTC.
+		{RotateHourly, false, time.Date(2024, 1, 2, 10, 45, 0, 0, india),
+			time.Date(2024, 1, 2, 5, 0, 0, 0, time.UTC), time.Date(2024, 1, 2, 6, 0, 0, 0, time.UTC)},
+		{RotateHourly, true, time.Date(2024, 1, 2, 10, 45, 0, 0, india),
+			time.Date(2024, 1, 2, 10, 0, 0, 0, india), time.Date(2024, 1, 2, 11, 0, 0, 0, india)},
+		// 05:00 in UTC+8 is still the previous day in UTC.
+		{RotateDaily, false, time.Date(2024, 1, 2, 5, 0, 0, 0, east8),
+			time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{RotateDaily, true, time.Date(2024, 1, 2, 5, 0, 0, 0, east8),
+			time.Date(2024, 1, 2, 0, 0, 0, 0, east8), time.Date(2024, 1, 3, 0, 0, 0, 0, east8)},
+		{RotateDaily, true, time.Date(2024, 2, 29, 23, 0, 0, 0, east8),
+			time.Date(2024, 2, 29, 0, 0, 0, 0, east8), time.Date(2024, 3, 1, 0, 0, 0, 0, east8)},
+		{"", false, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), time.Time{}, time.Time{}},
+	}
+	for _, tt := range tests {
+		l := &Logger{RotateEvery: tt.every, LocalTime: tt.local}
+		if got := l.boundary(tt.t, 0); !got.Equal(tt.cur) {
+			t.Errorf("%q local %v: boundary(%v, 0) = %v, want %v", tt.every, tt.local, tt.t, got, tt.cur)
+		}
+		if got := l.boundary(tt.t, 1); !got.Equal(tt.next) {
+			t.Errorf("%q local %v: boundary(%v, 1) = %v, want %v", tt.every, tt.local, tt.t, got, tt.next)
+		}
+	}
+}
+
+func TestRotateHourly(t *testing.T) {
+	useFakeTime(t)
+	l, _, back := backDirLogger(t, Options().SetRotateEvery(RotateHourly))
+	mustWrite(t, l, "a\n")
+	setTime(time.Date(2024, 1, 2, 3, 59, 59, 0, time.UTC))
+	mustWrite(t, l, "b\n")
+	if got := names(t, back); len(got) != 0 {
+		t.Fatalf("rotated within the hour: %v", got)
+	}
+
+	setTime(time.Date(2024, 1, 2, 4, 0, 0, 0, time.UTC))
+	mustWrite(t, l, "c\n")
+	want := "app-2024-01-02T04-00-00.000.log"
+	if got := names(t, back); len(got) != 1 || got[0] != want {
+		t.Fatalf("backup dir holds %v, want %s", got, want)
+	}
+	if got := backupContents(t, l); len(got) != 1 || got[0] != "a\nb\n" {
+		t.Errorf("backups = %q", got)
+	}
+	if got := readFile(t, l.filename()); got != "c\n" {
+		t.Errorf("current file = %q", got)
+	}
+
+	// the next boundary is an hour later, not an hour after the write.
+	setTime(time.Date(2024, 1, 2, 4, 59, 0, 0, time.UTC))
+	mustWrite(t, l, "d\n")
+	setTime(time.Date(2024, 1, 2, 5, 0, 1, 0, time.UTC))
+	mustWrite(t, l, "e\n")
+	if got := backupContents(t, l); strings.Join(got, "|") != "a\nb\n|c\nd\n" {
+		t.Errorf("backups = %q", got)
+	}
+}
+
+func TestRotateDailyLocalTime(t *testing.T) {
+	east8 := time.FixedZone("UTC+8", 8*3600)
+	tests := []struct {
+		local bool
+		want  []string
+	}{
+		// midnight in UTC+8 is 16:00 UTC, so only local time crosses a day.
+		{true, []string{"app-2024-01-03T00-00-00.000.log"}},
+		{false, nil},
+	}
+	for _, tt := range tests {
+		useFakeTime(t)
+		setTime(time.Date(2024, 1, 2, 23, 59, 0, 0, east8))
+		l, _, back := backDirLogger(t, Options().SetRotateEvery(RotateDaily).SetLocalTime(tt.local))
+		mustWrite(t, l, "before midnight\n")
+		advance(time.Minute)
+		mustWrite(t, l, "after midnight\n")
+		if got := names(t, back); strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("local %v: backup dir holds %v, want %v", tt.local, got, tt.want)
+		}
+	}
+}
+
+func TestRotateSizeOrTime(t *testing.T) {
+	useFakeTime(t)
+	l, _, back := backDirLogger(t, Options().SetMaxSize(10).SetRotateEvery(RotateHourly))
+	// size triggers first within the hour.
+	mustWrite(t, l, "first.\n")
+	advance(time.Second)
+	mustWrite(t, l, "second\n")
+	if got := names(t, back); len(got) != 1 || got[0] != "app-2024-01-02T03-04-06.000.log" {
+		t.Fatalf("after the size limit backup dir holds %v", got)
+	}
+	// time triggers first at the next hour: the file would still fit.
+	setTime(time.Date(2024, 1, 2, 4, 0, 0, 0, time.UTC))
+	mustWrite(t, l, "3\n")
+	want := []string{"app-2024-01-02T03-04-06.000.log", "app-2024-01-02T04-00-00.000.log"}
+	if got := names(t, back); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("backup dir holds %v, want %v", got, want)
+	}
+	if got := backupContents(t, l); strings.Join(got, "|") != "first.\n|second\n" {
+		t.Errorf("backups = %q", got)
+	}
+	if got := readFile(t, l.filename()); got != "3\n" {
+		t.Errorf("current file = %q", got)
+	}
+}
+
+func TestRotateReopenedFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		modTime time.Time
+		rotated bool
+	}{
+		{"earlier hour", startTime.Add(-time.Hour), true},
+		{"earlier day", startTime.Add(-24 * time.Hour), true},
+		{"same hour", startTime.Add(-time.Minute), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeTime(t)
+			l, cur, _ := backDirLogger(t, Options().SetRotateEvery(RotateHourly))
+			if err := os.MkdirAll(cur, 0755); err != nil {
+				t.Fatal(err)
+			}
+			name := filepath.Join(cur, "app.log")
+			if err := os.WriteFile(name, []byte("old\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(name, tt.modTime, tt.modTime); err != nil {
+				t.Fatal(err)
+			}
+			mustWrite(t, l, "new\n")
+
+			if tt.rotated {
+				if got := backupContents(t, l); len(got) != 1 || got[0] != "old\n" {
+					t.Errorf("backups = %q, want the reopened file", got)
+				}
+				if got := readFile(t, name); got != "new\n" {
+					t.Errorf("current file = %q", got)
+				}
+			} else {
+				if got := backupContents(t, l); len(got) != 0 {
+					t.Errorf("backups = %q, want the file appended to", got)
+				}
+				if got := readFile(t, name); got != "old\nnew\n" {
+					t.Errorf("current file = %q", got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package logrotate
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBackDirSpaceBudget(t *testing.T) {
+	// sizes in bytes, megabyte being 1 in the tests. Compressed and
+	// uncompressed backups count alike; pruning doesn't read them.
+	backups := []struct {
+		name string
+		size int
+	}{
+		{"app-2024-01-02T03-04-05.000.log.gz", 30},
+		{"app-2024-01-02T03-04-06.000.log", 40},
+		{"app-2024-01-02T03-04-07.000.log.zst", 25},
+		{"app-2024-01-02T03-04-08.000.log", 35},
+	}
+	const active = 50
+	tests := []struct {
+		name        string
+		maxTotal    int
+		minFree     int
+		keep        []string // oldest first
+		wantRemoved int64
+	}{
+		{"no limit", 0, 0, []string{"05", "06", "07", "08"}, 0},
+		{"everything fits", active + 30 + 40 + 25 + 35, 0, []string{"05", "06", "07", "08"}, 0},
+		{"exact fit", active + 25 + 35, 0, []string{"07", "08"}, 2},
+		{"one byte over", active + 25 + 35 - 1, 0, []string{"08"}, 3},
+		{"active file alone is over", active - 1, 0, nil, 4},
+		{"free space can't be reached", 0, 1 << 62, nil, 4},
+		{"enough free space", 0, 1, []string{"05", "06", "07", "08"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeTime(t)
+			opts := Options().SetMaxTotalSize(tt.maxTotal).SetMinFreeSpace(tt.minFree)
+			l, cur, back := backDirLogger(t, opts)
+			for _, dir := range []string{cur, back} {
+				if err := os.MkdirAll(dir, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, b := range backups {
+				if err := os.WriteFile(filepath.Join(back, b.name), make([]byte, b.size), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			content := strings.Repeat("x", active)
+			if err := os.WriteFile(l.filename(), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := l.millRunOnce(); err != nil {
+				t.Fatal(err)
+			}
+			var want []string
+			for _, b := range backups {
+				for _, k := range tt.keep {
+					if strings.Contains(b.name, "04-"+k+".000") {
+						want = append(want, b.name)
+					}
+				}
+			}
+			if got := names(t, back); strings.Join(got, " ") != strings.Join(want, " ") {
+				t.Errorf("backup dir holds %v, want %v", got, want)
+			}
+			if got := readFile(t, l.filename()); got != content {
+				t.Errorf("active file changed to %d bytes", len(got))
+			}
+			if got := l.Stats().Removed; got != tt.wantRemoved {
+				t.Errorf("removed = %d, want %d", got, tt.wantRemoved)
+			}
+		})
+	}
+}
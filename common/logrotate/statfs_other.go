@@ -0,0 +1,10 @@
+//go:build !unix
+
+package logrotate
+
+import "errors"
+
+// diskFree is not supported here; MinFreeSpace then never removes backups.
+func diskFree(dir string) (int64, error) {
+	return 0, errors.New("disk free space not supported on this platform")
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// archiveHookTimeout 归档命令的最长执行时间,超时后被杀掉,避免卡住日志的清理和压缩
+const archiveHookTimeout = 10 * time.Minute
+
+// archiveHook 返回 Logger.OnArchive 回调: 以备份文件的路径作为最后一个参数执行 argv,
+// 例如上传到对象存储.由 Logger 的 mill goroutine 调用,结果和耗时写入 launch 的日志
+func archiveHook(name string, argv []string) func(path string) {
+	return func(path string) {
+		ctx, cancel := context.WithTimeout(context.Background(), archiveHookTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, argv[0], append(argv[1:], path)...)
+		cmd.Env = append(os.Environ(), "LAUNCH_PROGRAM="+name, "LAUNCH_ARCHIVE="+path)
+		start := time.Now()
+		out, err := cmd.CombinedOutput()
+		elapsed := time.Since(start)
+		if err != nil {
+			out = bytes.TrimSpace(out)
+			slog.Error("archive hook failed",
+				"name", name,
+				"file", path,
+				"duration", elapsed,
+				"err", err,
+				"output", string(out[:min(len(out), 200)]),
+			)
+			return
+		}
+		slog.Info("archive hook done", "name", name, "file", path, "duration", elapsed)
+	}
+}
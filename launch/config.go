@@ -0,0 +1,456 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/ndsky1003/cmd/common/logrotate"
+	"gopkg.in/yaml.v3"
+)
+
+// Config launch 的配置文件,根据扩展名解析 yaml/yml/json/toml
+type Config struct {
+	// Log launch 自身的日志,Filename 默认 launch.log,BackDir 默认 log
+	Log *logrotate.Logger `json:"log" yaml:"log"`
+	// Sock 控制命令使用的 unix socket,为空使用 -sock 参数
+	Sock string `json:"sock" yaml:"sock"`
+	// Metrics /metrics 的监听地址,为空使用 -metrics 参数
+	Metrics string `json:"metrics" yaml:"metrics"`
+	// ArchiveHook launch 自身日志的归档命令,见 ProgramConfig.ArchiveHook
+	ArchiveHook []string         `json:"archivehook" yaml:"archivehook"`
+	Programs    []*ProgramConfig `json:"programs" yaml:"programs"`
+}
+
+// ProgramConfig 一个子进程的配置
+type ProgramConfig struct {
+	Name    string   `json:"name" yaml:"name"`
+	Command string   `json:"command" yaml:"command"`
+	Args    []string `json:"args" yaml:"args"`
+	// Env KEY=VAL 形式,覆盖继承的和 EnvFile 中的环境变量
+	Env []string `json:"env" yaml:"env"`
+	// EnvFile dotenv 格式的文件,按顺序读取,覆盖继承的环境变量
+	EnvFile []string `json:"envfile" yaml:"envfile"`
+	// ClearEnv 不继承 launch 的环境变量,EnvAllow 中的除外(支持 * 通配,例如 LC_*)
+	ClearEnv bool     `json:"clearenv" yaml:"clearenv"`
+	EnvAllow []string `json:"envallow" yaml:"envallow"`
+	// Dir 子进程的工作目录,为空则继承 launch 的工作目录
+	Dir string `json:"dir" yaml:"dir"`
+	// User 子进程的用户,name、uid、name:group 或 uid:gid,为空则与 launch 相同.
+	// launch 仍以原来的身份写日志
+	User string `json:"user" yaml:"user"`
+	// Groups 附加组,为空时使用 User 所属的组
+	Groups []string `json:"groups" yaml:"groups"`
+	// Rlimits、Nice、IONice(class[:level],class 为 realtime|best-effort|idle)
+	// 在子进程执行前设置,仅支持 Linux
+	Rlimits Rlimits `json:"rlimits" yaml:"rlimits"`
+	Nice    int     `json:"nice" yaml:"nice"`
+	IONice  string  `json:"ionice" yaml:"ionice"`
+
+	// Log stdout 的日志,Filename 默认 <name>.log,BackDir 默认 log
+	Log *logrotate.Logger `json:"log" yaml:"log"`
+	// Stderr 非空时 stderr 单独写入该日志,未配置的字段与 Log 相同,
+	// Compress、Compression、CompressLevel、Manifest 和 LocalTime 总是与 Log 一致
+	Stderr *logrotate.Logger `json:"stderr" yaml:"stderr"`
+	// StderrMirror stderr 单独记录时同时写入 Log
+	StderrMirror bool `json:"stderrmirror" yaml:"stderrmirror"`
+	// LineFormat 见 -linefmt
+	LineFormat string `json:"lineformat" yaml:"lineformat"`
+	MaxLine    int    `json:"maxline" yaml:"maxline"`
+	// Syslog 同时把输出按行发送到这些 syslog,stdout 和 stderr 使用不同的级别
+	Syslog []*Syslog `json:"syslog" yaml:"syslog"`
+	// PTY 子进程的 stdin/stdout/stderr 连接到伪终端,让按终端决定缓冲和颜色的程序
+	// 以交互方式输出.stdout 和 stderr 合并写入 Log,不能与 Stderr 同时使用
+	PTY bool `json:"pty" yaml:"pty"`
+	// Tee 把原始输出同时写到 launch 自己的 stdout/stderr,TeeColor 时 stderr 显示为红色
+	Tee      bool `json:"tee" yaml:"tee"`
+	TeeColor bool `json:"teecolor" yaml:"teecolor"`
+	// Stdin 把 launch 的 stdin 传给子进程,只能有一个子进程开启
+	Stdin bool `json:"stdin" yaml:"stdin"`
+	// StripANSI 去掉输出中的 ANSI 转义序列(颜色、光标移动等),不开启 PTY 也可使用
+	StripANSI bool `json:"stripansi" yaml:"stripansi"`
+	// ArchiveHook 备份完成(压缩后,未开启压缩时为轮转后)执行的命令及参数,
+	// 备份文件的路径作为最后一个参数,Log 和 Stderr 的备份都会执行
+	ArchiveHook []string `json:"archivehook" yaml:"archivehook"`
+
+	Restart Restart `json:"restart" yaml:"restart"`
+	Group   bool    `json:"group" yaml:"group"`
+	// StopGrace 见 -stopgrace,为 0 时使用默认值 10s,负数表示一直等待
+	StopGrace Duration `json:"stopgrace" yaml:"stopgrace"`
+	// Probe 存活探针,为空不探测
+	Probe *Probe `json:"probe" yaml:"probe"`
+}
+
+// Duration 配置文件中的时间间隔,可以写成 "5s" 这样的字符串或纳秒数
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d *Duration) parse(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		return d.parse(s)
+	}
+	var n int64
+	if err := json.Unmarshal(b, &n); err != nil {
+		return fmt.Errorf("bad duration %s, want \"5s\" or nanoseconds", b)
+	}
+	*d = Duration(n)
+	return nil
+}
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	var n int64
+	if value.Tag == "!!int" && value.Decode(&n) == nil {
+		*d = Duration(n)
+		return nil
+	}
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+	return d.parse(s)
+}
+
+func (d *Duration) UnmarshalTOML(v any) error {
+	switch v := v.(type) {
+	case int64:
+		*d = Duration(v)
+		return nil
+	case string:
+		return d.parse(v)
+	}
+	return fmt.Errorf("bad duration %v, want \"5s\" or nanoseconds", v)
+}
+
+// decodeConfig 根据扩展名解析配置文件
+func decodeConfig(path string, data []byte, v any) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		return toml.Unmarshal(data, v)
+	case ".yaml", ".yml":
+		return yaml.Unmarshal(data, v)
+	case ".json":
+		return json.Unmarshal(data, v)
+	}
+	return errors.New("unsupported config format")
+}
+
+// loadConfig 读取并校验配置文件
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := decodeConfig(path, data, cfg); err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	if len(cfg.Programs) == 0 {
+		return nil, errors.New("no programs in config")
+	}
+	if cfg.Log == nil {
+		cfg.Log = &logrotate.Logger{}
+	}
+	if cfg.Log.Filename == "" {
+		cfg.Log.Filename = "launch.log"
+	}
+	if cfg.Log.BackDir == "" {
+		cfg.Log.BackDir = "log"
+	}
+	retentionDefaults(cfg.Log)
+	// 与 -localtime 一致,默认使用本地时间,配置文件中明确写了 localtime 的除外
+	lt, err := configLocalTime(path, data)
+	if err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	if lt.Log == nil || lt.Log.LocalTime == nil {
+		cfg.Log.LocalTime = true
+	}
+	names := make(map[string]bool)
+	stdin := ""
+	for i, pc := range cfg.Programs {
+		if pc.Command == "" {
+			return nil, fmt.Errorf("programs[%d]: command is required", i)
+		}
+		if pc.Name == "" {
+			pc.Name = strings.TrimSuffix(filepath.Base(pc.Command), filepath.Ext(pc.Command))
+		}
+		if names[pc.Name] {
+			return nil, fmt.Errorf("programs[%d]: duplicate name %q", i, pc.Name)
+		}
+		names[pc.Name] = true
+		if pc.Log == nil {
+			pc.Log = &logrotate.Logger{}
+		}
+		if l := lt.Programs[i].Log; l == nil || l.LocalTime == nil {
+			pc.Log.LocalTime = true
+		}
+		if pc.Stdin {
+			if stdin != "" {
+				return nil, fmt.Errorf("programs[%d]: stdin already passed to %q", i, stdin)
+			}
+			stdin = pc.Name
+		}
+		if err := pc.validate(); err != nil {
+			return nil, fmt.Errorf("program %s: %w", pc.Name, err)
+		}
+	}
+	return cfg, nil
+}
+
+// retentionDefaults 补全与 -maxbackups、-maxage 相同的默认值,负数表示不限制
+func retentionDefaults(l *logrotate.Logger) {
+	if l.MaxBackups == 0 {
+		l.MaxBackups = defaultMaxBackups
+	}
+	if l.MaxAge == 0 {
+		l.MaxAge = defaultMaxAge
+	}
+}
+
+// configLocalTime 只解析配置文件中各日志的 localtime,用于区分未设置和设置为 false
+func configLocalTime(path string, data []byte) (*localTimeConfig, error) {
+	lt := &localTimeConfig{}
+	if err := decodeConfig(path, data, lt); err != nil {
+		return nil, err
+	}
+	return lt, nil
+}
+
+type localTimeConfig struct {
+	Log      *localTimeLog `json:"log" yaml:"log"`
+	Programs []struct {
+		Log *localTimeLog `json:"log" yaml:"log"`
+	} `json:"programs" yaml:"programs"`
+}
+
+type localTimeLog struct {
+	LocalTime *bool `json:"localtime" yaml:"localtime"`
+}
+
+// validate 校验配置并补全默认值
+func (pc *ProgramConfig) validate() error {
+	if pc.Log == nil {
+		pc.Log = &logrotate.Logger{}
+	}
+	if pc.Log.Filename == "" {
+		pc.Log.Filename = pc.Name + ".log"
+	}
+	if pc.Log.BackDir == "" {
+		pc.Log.BackDir = "log"
+	}
+	retentionDefaults(pc.Log)
+	if err := pc.Log.Validate(); err != nil {
+		return err
+	}
+	if pc.PTY && pc.Stderr != nil {
+		return errors.New("pty merges stderr into stdout, stderr log can't be used")
+	}
+	if s := pc.Stderr; s != nil {
+		if s.Filename == "" {
+			s.Filename = pc.Name + ".err.log"
+		}
+		if s.BackDir == "" {
+			s.BackDir = pc.Log.BackDir
+		}
+		if s.MaxSize == 0 {
+			s.MaxSize = pc.Log.MaxSize
+		}
+		if s.MaxBackups == 0 {
+			s.MaxBackups = pc.Log.MaxBackups
+		}
+		if s.MaxAge == 0 {
+			s.MaxAge = pc.Log.MaxAge
+		}
+		if s.RotateEvery == "" {
+			s.RotateEvery = pc.Log.RotateEvery
+		}
+		if s.MaxTotalSize == 0 {
+			s.MaxTotalSize = pc.Log.MaxTotalSize
+		}
+		if s.MinFreeSpace == 0 {
+			s.MinFreeSpace = pc.Log.MinFreeSpace
+		}
+		if s.Oversize == "" {
+			s.Oversize = pc.Log.Oversize
+		}
+		s.Compress = pc.Log.Compress
+		s.Compression = pc.Log.Compression
+		s.CompressLevel = pc.Log.CompressLevel
+		s.Manifest = pc.Log.Manifest
+		s.LocalTime = pc.Log.LocalTime
+		if err := s.Validate(); err != nil {
+			return err
+		}
+	}
+	switch pc.LineFormat {
+	case lineFormatRaw, lineFormatText, lineFormatJSON:
+	default:
+		return fmt.Errorf("unknown line format: %s", pc.LineFormat)
+	}
+	for _, s := range pc.Syslog {
+		if err := s.validate(); err != nil {
+			return err
+		}
+	}
+	if pc.Probe != nil {
+		if err := pc.Probe.validate(); err != nil {
+			return err
+		}
+	}
+	for _, kv := range pc.Env {
+		if k, _, ok := strings.Cut(kv, "="); !ok || k == "" {
+			return fmt.Errorf("bad env %q, want KEY=VAL", kv)
+		}
+	}
+	if len(pc.Groups) > 0 && pc.User == "" {
+		return errors.New("groups needs user")
+	}
+	if _, err := newResources(pc.Rlimits, pc.Nice, pc.IONice); err != nil {
+		return err
+	}
+	// 与命令行参数的默认值一致
+	if pc.StopGrace == 0 {
+		pc.StopGrace = Duration(defaultStopGrace)
+	}
+	r := &pc.Restart
+	if r.MaxRestarts == 0 {
+		r.MaxRestarts = defaultMaxRestarts
+	}
+	if r.Policy == "" {
+		r.Policy = RestartNever
+	}
+	if !r.Policy.valid() {
+		return fmt.Errorf("unknown restart policy: %s", r.Policy)
+	}
+	if r.Delay == 0 {
+		r.Delay = Duration(time.Second)
+	}
+	if r.MaxDelay == 0 {
+		r.MaxDelay = Duration(time.Minute)
+	}
+	if r.Window == 0 {
+		r.Window = Duration(time.Minute)
+	}
+	return nil
+}
+
+// build 根据配置创建 program 以及它的日志
+func (pc *ProgramConfig) build() (*program, error) {
+	exepath := pc.Command
+	// 含路径分隔符的命令相对于 Dir 解析,交给 exec 处理
+	if !strings.Contains(pc.Command, string(filepath.Separator)) {
+		var err error
+		if exepath, err = exec.LookPath(pc.Command); err != nil {
+			return nil, err
+		}
+	}
+	p := &program{
+		Name:      pc.Name,
+		Path:      exepath,
+		Args:      pc.Args,
+		Env:       pc.Env,
+		Dir:       pc.Dir,
+		Restart:   pc.Restart,
+		Group:     pc.Group,
+		StopGrace: time.Duration(pc.StopGrace),
+		Probe:     pc.Probe,
+		PTY:       pc.PTY,
+		Stdin:     pc.Stdin,
+	}
+	var userEnv []string
+	if pc.User != "" {
+		var err error
+		if p.Credential, userEnv, err = credential(pc.User, pc.Groups); err != nil {
+			return nil, err
+		}
+	}
+	// 用户的 HOME 等放在 env 文件和 Env 之前,可以被覆盖
+	var err error
+	if p.Env, err = environ(pc.ClearEnv, pc.EnvAllow, userEnv, pc.EnvFile, pc.Env); err != nil {
+		return nil, err
+	}
+	if p.Resources, err = newResources(pc.Rlimits, pc.Nice, pc.IONice); err != nil {
+		return nil, err
+	}
+
+	logs := []*logrotate.Logger{pc.Log}
+	var stdout, stderr io.Writer = pc.Log, pc.Log
+	if pc.Stderr != nil {
+		logs = append(logs, pc.Stderr)
+		stderr = pc.Stderr
+		if pc.StderrMirror {
+			stderr = io.MultiWriter(pc.Stderr, pc.Log)
+		}
+	}
+	for _, l := range logs {
+		if err := os.MkdirAll(l.BackDir, 0755); err != nil {
+			return nil, err
+		}
+		if len(pc.ArchiveHook) > 0 {
+			l.OnArchive = archiveHook(pc.Name, pc.ArchiveHook)
+		}
+	}
+	// tail 看到的内容与日志文件一致,放在按行格式化之后
+	p.tap = &broadcast{}
+	stdout = io.MultiWriter(stdout, p.tap)
+	stderr = io.MultiWriter(stderr, p.tap)
+	if pc.LineFormat != lineFormatRaw {
+		outLines := newLineWriter(stdout, "stdout", pc.LineFormat, pc.MaxLine, pc.Log.LocalTime)
+		errLines := newLineWriter(stderr, "stderr", pc.LineFormat, pc.MaxLine, pc.Log.LocalTime)
+		p.lines = []*lineWriter{outLines, errLines}
+		stdout, stderr = outLines, errLines
+	}
+	// syslog 收到的是子进程的原始行,时间和 pid 在消息头中.
+	// 放在前面,日志文件写入失败时 syslog 仍能收到
+	if len(pc.Syslog) > 0 {
+		for _, s := range pc.Syslog {
+			p.sinks = append(p.sinks, newSyslogSink(s, pc.Name))
+		}
+		outSys := newSyslogWriter(p.sinks, pc.Syslog, "stdout", pc.MaxLine)
+		errSys := newSyslogWriter(p.sinks, pc.Syslog, "stderr", pc.MaxLine)
+		p.lines = append(p.lines, outSys, errSys)
+		stdout, stderr = io.MultiWriter(outSys, stdout), io.MultiWriter(errSys, stderr)
+	}
+	// 在按行格式化之前处理终端输出,PTY 模式下还原终端的 \r\n
+	if pc.PTY || pc.StripANSI {
+		outFilter := &termFilter{w: stdout, crlf: pc.PTY, ansi: pc.StripANSI}
+		errFilter := &termFilter{w: stderr, crlf: pc.PTY, ansi: pc.StripANSI}
+		p.filters = []*termFilter{outFilter, errFilter}
+		stdout, stderr = outFilter, errFilter
+	}
+	// 终端上看到子进程的原始输出,不受 StripANSI 影响
+	if pc.Tee {
+		outTee, errTee := &teeWriter{w: os.Stdout}, &teeWriter{w: os.Stderr}
+		if pc.TeeColor {
+			errTee.color = teeColorStderr
+		}
+		stdout, stderr = io.MultiWriter(outTee, stdout), io.MultiWriter(errTee, stderr)
+	}
+	// 统计子进程的原始输出
+	outCount, errCount := &countWriter{w: stdout}, &countWriter{w: stderr}
+	p.counters = map[string]*countWriter{"stdout": outCount, "stderr": errCount}
+	p.Stdout = outCount
+	p.Stderr = errCount
+	p.logs = logs
+	return p, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTemp(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// 三种格式描述同一份配置: a 设置了各字段,b 全部使用默认值
+var configFormats = []struct {
+	name, content string
+}{
+	{"launch.yaml", `
+programs:
+  - name: a
+    command: sh
+    args: ["-c", "echo a/b"]
+    stopgrace: 5s
+    restart: {delay: 2000000000, maxrestarts: -1}
+    log: {localtime: false, maxbackups: -1}
+  - command: /bin/b
+`},
+	{"launch.toml", `
+[[programs]]
+name = "a"
+command = "sh"
+args = ["-c", "echo a/b"]
+stopgrace = "5s"
+restart = {delay = 2000000000, maxrestarts = -1}
+log = {localtime = false, maxbackups = -1}
+
+[[programs]]
+command = "/bin/b"
+`},
+	{"launch.json", `{"programs": [
+  {"name": "a", "command": "sh", "args": ["-c", "echo a\/b"], "stopgrace": "5s",
+   "restart": {"delay": 2000000000, "maxrestarts": -1},
+   "log": {"localtime": false, "maxbackups": -1}},
+  {"command": "/bin/b"}
+]}`},
+}
+
+func TestLoadConfig(t *testing.T) {
+	for _, f := range configFormats {
+		t.Run(f.name, func(t *testing.T) {
+			cfg, err := loadConfig(writeTemp(t, f.name, f.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if l := cfg.Log; l.Filename != "launch.log" || l.BackDir != "log" || !l.LocalTime ||
+				l.MaxBackups != defaultMaxBackups || l.MaxAge != defaultMaxAge {
+				t.Errorf("launch log %s: localtime = %v, maxbackups = %d, maxage = %d, want flag defaults",
+					l.Filename, l.LocalTime, l.MaxBackups, l.MaxAge)
+			}
+			if len(cfg.Programs) != 2 {
+				t.Fatalf("got %d programs", len(cfg.Programs))
+			}
+
+			a := cfg.Programs[0]
+			if !reflect.DeepEqual(a.Args, []string{"-c", "echo a/b"}) {
+				t.Errorf("a args = %q", a.Args)
+			}
+			if a.StopGrace != Duration(5*time.Second) || a.Restart.Delay != Duration(2*time.Second) {
+				t.Errorf("a stopgrace = %v, restart delay = %v, want 5s, 2s", a.StopGrace, a.Restart.Delay)
+			}
+			if a.Restart.MaxRestarts != -1 || a.Log.MaxBackups != -1 || a.Log.LocalTime {
+				t.Errorf("a maxrestarts = %d, maxbackups = %d, localtime = %v, want -1, -1, false",
+					a.Restart.MaxRestarts, a.Log.MaxBackups, a.Log.LocalTime)
+			}
+			if a.Log.MaxAge != defaultMaxAge || a.Log.Filename != "a.log" {
+				t.Errorf("a log %s: maxage = %d", a.Log.Filename, a.Log.MaxAge)
+			}
+
+			b := cfg.Programs[1]
+			if b.Name != "b" || b.Log.Filename != "b.log" {
+				t.Errorf("b name = %q, log = %q", b.Name, b.Log.Filename)
+			}
+			if b.StopGrace != Duration(defaultStopGrace) || b.Restart.MaxRestarts != defaultMaxRestarts ||
+				b.Restart.Delay != Duration(time.Second) || b.Restart.Policy != RestartNever {
+				t.Errorf("b stopgrace = %v, restart = %+v, want defaults", b.StopGrace, b.Restart)
+			}
+			if !b.Log.LocalTime || b.Log.MaxBackups != defaultMaxBackups || b.Log.MaxAge != defaultMaxAge {
+				t.Errorf("b log: localtime = %v, maxbackups = %d, maxage = %d, want flag defaults",
+					b.Log.LocalTime, b.Log.MaxBackups, b.Log.MaxAge)
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		file, content, want string
+	}{
+		{"launch.ini", "", "unsupported config format"},
+		{"launch.yaml", "programs: []", "no programs"},
+		{"launch.json", `{"programs": [{"command": "sh", "stopgrace": "5 seconds"}]}`, "duration"},
+		{"launch.json", `{"programs": [{"command": "sh", "stopgrace": true}]}`, "bad duration"},
+		{"launch.yaml", "programs: [{command: sh, stopgrace: soon}]", "duration"},
+		{"launch.toml", "[[programs]]\ncommand = \"sh\"\nstopgrace = 1.5", "bad duration"},
+		{"launch.yaml", "programs: [{command: sh}, {command: sh}]", "duplicate name"},
+		{"launch.yaml", "programs: [{command: sh, restart: {policy: sometimes}}]", "unknown restart policy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file+" "+tt.want, func(t *testing.T) {
+			_, err := loadConfig(writeTemp(t, tt.file, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("loadConfig(%q) = %v, want error containing %q", tt.content, err, tt.want)
+			}
+		})
+	}
+}
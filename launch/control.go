@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// 控制命令,通过 unix socket 发送给正在运行的 launch
+var controlCommands = []string{"status", "start", "stop", "restart", "rotate", "tail"}
+
+func isControlCommand(cmd string) bool {
+	for _, c := range controlCommands {
+		if c == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// ctlRequest 控制请求,每个连接一个,json 编码
+type ctlRequest struct {
+	Cmd   string `json:"cmd"`
+	Name  string `json:"name,omitempty"`  // 为空表示所有子进程
+	Lines int    `json:"lines,omitempty"` // tail 先输出的行数
+}
+
+// ctlResponse 控制响应;tail 在响应之后持续输出子进程的日志
+type ctlResponse struct {
+	Error    string          `json:"error,omitempty"`
+	Programs []programStatus `json:"programs,omitempty"`
+}
+
+// programStatus 子进程的运行状态
+type programStatus struct {
+	Name       string    `json:"name"`
+	State      string    `json:"state"`
+	Pid        int       `json:"pid,omitempty"`
+	Started    time.Time `json:"started,omitzero"`
+	Uptime     string    `json:"uptime,omitempty"`
+	Restarts   int       `json:"restarts"`
+	LastResult string    `json:"last_result,omitempty"`
+	LastCode   int       `json:"last_code,omitempty"`
+	LastSignal string    `json:"last_signal,omitempty"`
+	LastError  string    `json:"last_error,omitempty"`
+	Log        string    `json:"log"`
+	LogSize    int64     `json:"log_size"`
+	Error      string    `json:"error,omitempty"` // 控制命令在该子进程上的执行结果
+}
+
+// status 返回子进程当前的状态
+func (p *program) status() programStatus {
+	p.mu.Lock()
+	st := programStatus{
+		Name:     p.Name,
+		State:    p.state,
+		Restarts: p.restarts,
+	}
+	if p.cmd != nil && p.cmd.Process != nil {
+		st.Pid = p.cmd.Process.Pid
+		st.Started = p.started
+		st.Uptime = time.Since(p.started).Round(time.Second).String()
+	}
+	if last := p.last; last != nil {
+		st.LastResult = last.result()
+		st.LastCode = last.exitCode()
+		st.LastSignal = signalName(last.Signal)
+		if last.Err != nil {
+			st.LastError = last.Err.Error()
+		}
+	}
+	p.mu.Unlock()
+	if len(p.logs) > 0 {
+		st.Log = p.logs[0].Filename
+		if info, err := os.Stat(st.Log); err == nil {
+			st.LogSize = info.Size()
+		}
+	}
+	return st
+}
+
+// serveControl 在 unix socket 上接受控制命令,socket 已被其他 launch 使用时返回错误
+func serveControl(path string, progs []*program) (net.Listener, error) {
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("control socket %s is in use", path)
+		}
+		// 上次异常退出遗留的 socket
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					slog.Error("control accept failed", "err", err)
+				}
+				return
+			}
+			go handleControl(conn, progs)
+		}
+	}()
+	return ln, nil
+}
+
+func handleControl(conn net.Conn, progs []*program) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	var req ctlRequest
+	if err := json.NewDecoder(r).Decode(&req); err != nil {
+		json.NewEncoder(conn).Encode(ctlResponse{Error: "bad request: " + err.Error()})
+		return
+	}
+	slog.Info("control command", "cmd", req.Cmd, "name", req.Name)
+
+	var targets []*program
+	for _, p := range progs {
+		if req.Name == "" || p.Name == req.Name {
+			targets = append(targets, p)
+		}
+	}
+	if len(targets) == 0 {
+		json.NewEncoder(conn).Encode(ctlResponse{Error: fmt.Sprintf("no such program: %s", req.Name)})
+		return
+	}
+
+	var resp ctlResponse
+	switch req.Cmd {
+	case "status":
+	case "start", "stop", "restart":
+		for _, p := range targets {
+			var err error
+			switch req.Cmd {
+			case "start":
+				err = p.ctlStart()
+			case "stop":
+				err = p.ctlStop()
+			case "restart":
+				err = p.ctlRestart()
+			}
+			if err != nil {
+				resp.Error = "some commands failed"
+				resp.Programs = append(resp.Programs, programStatus{Name: p.Name, Error: err.Error()})
+			}
+		}
+		if resp.Error == "" {
+			resp.Programs = nil
+		}
+	case "rotate":
+		for _, p := range targets {
+			for _, l := range p.logs {
+				if err := l.Rotate(); err != nil {
+					resp.Error = "some commands failed"
+					resp.Programs = append(resp.Programs, programStatus{Name: p.Name, Log: l.Filename, Error: err.Error()})
+				}
+			}
+		}
+	case "tail":
+		if len(targets) != 1 {
+			json.NewEncoder(conn).Encode(ctlResponse{Error: "tail needs a program name"})
+			return
+		}
+		tailProgram(conn, r, targets[0], req.Lines)
+		return
+	default:
+		json.NewEncoder(conn).Encode(ctlResponse{Error: "unknown command: " + req.Cmd})
+		return
+	}
+	if req.Cmd == "status" || resp.Error == "" {
+		resp.Programs = resp.Programs[:0]
+		for _, p := range targets {
+			resp.Programs = append(resp.Programs, p.status())
+		}
+	}
+	json.NewEncoder(conn).Encode(resp)
+}
+
+// tailProgram 先输出日志文件的最后 lines 行,再持续输出子进程的新输出,直到客户端断开
+func tailProgram(conn net.Conn, r io.Reader, p *program, lines int) {
+	ch := p.tap.subscribe()
+	defer p.tap.unsubscribe(ch)
+	if err := json.NewEncoder(conn).Encode(ctlResponse{Programs: []programStatus{p.status()}}); err != nil {
+		return
+	}
+	if lines > 0 && len(p.logs) > 0 {
+		if b, err := lastLines(p.logs[0].Filename, lines); err == nil {
+			if _, err := conn.Write(b); err != nil {
+				return
+			}
+		}
+	}
+	closed := make(chan struct{})
+	go func() {
+		// 客户端不再发送数据,读到 EOF 表示断开
+		io.Copy(io.Discard, r)
+		close(closed)
+	}()
+	for {
+		select {
+		case b := <-ch:
+			if _, err := conn.Write(b); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// lastLines 读取文件最后 n 行,最多读取 1M
+func lastLines(path string, n int) ([]byte, error) {
+	const maxRead = 1 << 20
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := min(info.Size(), maxRead)
+	buf := make([]byte, size)
+	if _, err := f.ReadAt(buf, info.Size()-size); err != nil && err != io.EOF {
+		return nil, err
+	}
+	end := len(buf)
+	if end > 0 && buf[end-1] == '\n' {
+		end--
+	}
+	for i := end - 1; i >= 0; i-- {
+		if buf[i] == '\n' {
+			if n--; n == 0 {
+				return buf[i+1:], nil
+			}
+		}
+	}
+	return buf, nil
+}
+
+// broadcast 把写入的数据分发给所有订阅者,订阅者来不及读取时丢弃,不会阻塞子进程
+type broadcast struct {
+	mu   sync.Mutex
+	subs map[chan []byte]struct{}
+}
+
+func (b *broadcast) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.subs) == 0 {
+		return len(p), nil
+	}
+	data := append([]byte(nil), p...)
+	for ch := range b.subs {
+		select {
+		case ch <- data:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+func (b *broadcast) subscribe() chan []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs == nil {
+		b.subs = make(map[chan []byte]struct{})
+	}
+	ch := make(chan []byte, 256)
+	b.subs[ch] = struct{}{}
+	return ch
+}
+
+func (b *broadcast) unsubscribe(ch chan []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subs, ch)
+}
+
+// runControl launch 作为客户端连接 sock 执行控制命令,返回退出码
+func runControl(sock, cmd string, args []string) int {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	lines := fs.Int("n", 10, "tail: number of log lines to show first")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: launch [-sock path] %s [name]\n", cmd)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	req := ctlRequest{Cmd: cmd, Name: fs.Arg(0)}
+	if cmd == "tail" {
+		req.Lines = *lines
+	}
+
+	if sock == "" {
+		fmt.Fprintf(os.Stderr, "launch %s: no control socket,use -sock path or sock in the config file\n", cmd)
+		return 2
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "connect %s: %v\n", sock, err)
+		return 1
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	dec := json.NewDecoder(conn)
+	var resp ctlResponse
+	if err := dec.Decode(&resp); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if resp.Error != "" {
+		fmt.Fprintln(os.Stderr, resp.Error)
+		for _, st := range resp.Programs {
+			if st.Error != "" {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", st.Name, st.Error)
+			}
+		}
+		return 1
+	}
+	switch cmd {
+	case "tail":
+		// json.Decoder 会多读,日志从 decoder 的缓冲开始
+		if _, err := io.Copy(os.Stdout, io.MultiReader(dec.Buffered(), conn)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	case "status":
+		printStatus(os.Stdout, resp.Programs)
+	default:
+		for _, st := range resp.Programs {
+			fmt.Printf("%s: %s ok\n", st.Name, cmd)
+		}
+	}
+	return 0
+}
+
+func printStatus(w io.Writer, programs []programStatus) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSTATE\tPID\tUPTIME\tRESTARTS\tLAST EXIT\tLOG\tSIZE")
+	for _, st := range programs {
+		pid, uptime := "-", "-"
+		if st.Pid > 0 {
+			pid = fmt.Sprint(st.Pid)
+			uptime = st.Uptime
+		}
+		last := "-"
+		if st.LastResult != "" {
+			parts := []string{st.LastResult, fmt.Sprintf("code=%d", st.LastCode)}
+			if st.LastSignal != "" {
+				parts = append(parts, "signal="+st.LastSignal)
+			}
+			if st.LastError != "" {
+				parts = append(parts, "err="+st.LastError)
+			}
+			last = strings.Join(parts, " ")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\t%d\n", st.Name, st.State, pid, uptime, st.Restarts, last, st.Log, st.LogSize)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// environ 生成子进程的环境变量,后面的覆盖前面的:
+// 继承的环境变量(clear 时只保留 allow 中的,支持 * 通配)、extra、env 文件、env
+func environ(clear bool, allow []string, extra []string, files []string, env []string) ([]string, error) {
+	// 非 nil,空的环境变量不会被 exec 当作继承
+	out := []string{}
+	for _, kv := range os.Environ() {
+		k, _, _ := strings.Cut(kv, "=")
+		if !clear || envAllowed(k, allow) {
+			out = append(out, kv)
+		}
+	}
+	out = append(out, extra...)
+	for _, f := range files {
+		kvs, err := readEnvFile(f)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, kvs...)
+	}
+	for _, kv := range env {
+		if k, _, ok := strings.Cut(kv, "="); !ok || k == "" {
+			return nil, fmt.Errorf("bad env %q, want KEY=VAL", kv)
+		}
+		out = append(out, kv)
+	}
+	return dedupEnv(out), nil
+}
+
+func envAllowed(key string, allow []string) bool {
+	for _, pattern := range allow {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// dedupEnv 去掉重复的变量,保留最后一个值,顺序为每个变量第一次出现的位置
+func dedupEnv(env []string) []string {
+	index := make(map[string]int)
+	out := make([]string, 0, len(env))
+	for _, kv := range env {
+		k, _, _ := strings.Cut(kv, "=")
+		if i, ok := index[k]; ok {
+			out[i] = kv
+			continue
+		}
+		index[k] = len(out)
+		out = append(out, kv)
+	}
+	return out
+}
+
+// readEnvFile 读取 dotenv 格式的文件: 每行 KEY=VAL,可以有 export 前缀;
+// # 开头的行和未加引号的值中空白之后的 # 是注释;
+// 单引号内原样保留,双引号内支持 \n \t \" \\ 转义,引号内的值可以跨行
+func readEnvFile(name string) ([]string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var env []string
+	r := bufio.NewReader(bytes.NewReader(data))
+	lineno := 0
+	for {
+		line, err := r.ReadString('\n')
+		if line == "" && err != nil {
+			break
+		}
+		lineno++
+		start := lineno
+		text := strings.TrimSpace(line)
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		text = strings.TrimPrefix(text, "export ")
+		key, val, ok := strings.Cut(text, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("%s:%d: want KEY=VAL", name, start)
+		}
+		val = strings.TrimLeft(val, " \t")
+		if val != "" && (val[0] == '"' || val[0] == '\'') {
+			// 引号没有闭合时继续读下一行
+			for {
+				v, rest, err := unquoteEnv(val)
+				if err == nil {
+					if rest = strings.TrimSpace(rest); rest != "" && rest[0] != '#' {
+						return nil, fmt.Errorf("%s:%d: unexpected %q after quoted value", name, start, rest)
+					}
+					val = v
+					break
+				}
+				next, rerr := r.ReadString('\n')
+				if next == "" && rerr != nil {
+					return nil, fmt.Errorf("%s:%d: unterminated quote", name, start)
+				}
+				lineno++
+				val += "\n" + strings.TrimRight(next, "\r\n")
+			}
+		} else {
+			if i := strings.Index(val, " #"); i >= 0 {
+				val = val[:i]
+			}
+			if i := strings.Index(val, "\t#"); i >= 0 {
+				val = val[:i]
+			}
+			val = strings.TrimSpace(val)
+		}
+		env = append(env, key+"="+val)
+	}
+	return env, nil
+}
+
+var errUnterminated = errors.New("unterminated quote")
+
+// unquoteEnv 解析以引号开头的 s,返回引号内的值和闭合引号之后的内容
+func unquoteEnv(s string) (val, rest string, err error) {
+	s = strings.TrimRight(s, "\r\n")
+	q := s[0]
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == q:
+			return b.String(), s[i+1:], nil
+		case c == '\\' && q == '"' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			default:
+				b.WriteByte(s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", errUnterminated
+}
+
+// secretWords 变量名中含有这些词(不区分大小写)的变量是敏感信息,记录日志时隐藏它的值
+var secretWords = []string{"SECRET", "PASS", "PWD", "TOKEN", "KEY", "CREDENTIAL", "AUTH", "PRIVATE", "DSN", "COOKIE"}
+
+// secretParts 容易出现在普通变量名中的词,只在作为以 _ 分隔的完整部分时才算敏感,例如 GITHUB_PAT 而不是 PATH
+var secretParts = []string{"PAT", "PIN"}
+
+// notSecret 含有 secretWords 但不是敏感信息的变量
+var notSecret = []string{"PWD", "OLDPWD"}
+
+// urlUserinfo 匹配值中 URL 的 userinfo,例如 postgres://user:pw@host 中的 user:pw
+var urlUserinfo = regexp.MustCompile(`([A-Za-z][A-Za-z0-9+.-]*://)[^/?#\s]+@`)
+
+// maskEnv 返回用于记录日志的环境变量,敏感变量的值替换为 ***,
+// 其余变量的值中 URL 的 userinfo 替换为 ***
+func maskEnv(env []string) []string {
+	out := make([]string, len(env))
+	for i, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		if secretEnv(k) {
+			out[i] = k + "=***"
+			continue
+		}
+		out[i] = k + "=" + urlUserinfo.ReplaceAllString(v, "${1}***@")
+	}
+	return out
+}
+
+// secretEnv 判断变量名是否表示敏感信息
+func secretEnv(key string) bool {
+	key = strings.ToUpper(key)
+	if slices.Contains(notSecret, key) {
+		return false
+	}
+	for _, w := range secretWords {
+		if strings.Contains(key, w) {
+			return true
+		}
+	}
+	for _, part := range strings.Split(key, "_") {
+		if slices.Contains(secretParts, part) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMaskEnv(t *testing.T) {
+	tests := []struct {
+		kv, want string
+	}{
+		{"HOME=/root", "HOME=/root"},
+		{"PATH=/usr/bin:/bin", "PATH=/usr/bin:/bin"},
+		{"PWD=/srv/app", "PWD=/srv/app"},
+		{"OLDPWD=/srv", "OLDPWD=/srv"},
+		{"DB_PASSWORD=hunter2", "DB_PASSWORD=***"},
+		{"PGPASSWORD=hunter2", "PGPASSWORD=***"},
+		{"MYSQL_PWD=hunter2", "MYSQL_PWD=***"},
+		{"GITHUB_PAT=ghp_x", "GITHUB_PAT=***"},
+		{"GITHUB_TOKEN=ghp_x", "GITHUB_TOKEN=***"},
+		{"AWS_SECRET_ACCESS_KEY=x", "AWS_SECRET_ACCESS_KEY=***"},
+		{"APIKEY=x", "APIKEY=***"},
+		{"api_key=x", "api_key=***"},
+		{"SENTRY_DSN=https://k@sentry.io/1", "SENTRY_DSN=***"},
+		{"EMPTY_TOKEN=", "EMPTY_TOKEN=***"},
+		{"DATABASE_URL=postgres://u:pw@db:5432/app", "DATABASE_URL=postgres://***@db:5432/app"},
+		{"REDIS_URL=redis://:pw@cache:6379", "REDIS_URL=redis://***@cache:6379"},
+		{"REPO=https://user@example.com/a.git", "REPO=https://***@example.com/a.git"},
+		{"OPTS=--db=mysql://root:p@ss@db/x --v", "OPTS=--db=mysql://***@db/x --v"},
+		{"UPSTREAM=http://example.com/a@b", "UPSTREAM=http://example.com/a@b"},
+		{"MAIL=user@example.com", "MAIL=user@example.com"},
+	}
+	env := make([]string, len(tests))
+	for i, tt := range tests {
+		env[i] = tt.kv
+	}
+	got := maskEnv(env)
+	for i, tt := range tests {
+		if got[i] != tt.want {
+			t.Errorf("maskEnv(%q) = %q, want %q", tt.kv, got[i], tt.want)
+		}
+	}
+	if env[4] != "DB_PASSWORD=hunter2" {
+		t.Errorf("maskEnv modified its input: %q", env[4])
+	}
+}
+
+func TestReadEnvFile(t *testing.T) {
+	tests := []struct {
+		name, content string
+		want          []string
+		wantErr       string
+	}{
+		{
+			name:    "plain",
+			content: "A=1\nB = 2\n\n# comment\n  # indented comment\nC=\n",
+			want:    []string{"A=1", "B=2", "C="},
+		},
+		{
+			name:    "export prefix",
+			content: "export A=1\nexport  B=2\n",
+			want:    []string{"A=1", "B=2"},
+		},
+		{
+			name:    "inline comment",
+			content: "A=1 # one\nB=2\t# two\nC=a#b\nD=#x\n",
+			want:    []string{"A=1", "B=2", "C=a#b", "D=#x"},
+		},
+		{
+			name:    "single quotes",
+			content: `A='a b # c \n "x"' # comment` + "\n",
+			want:    []string{`A=a b # c \n "x"`},
+		},
+		{
+			name:    "double quotes and escapes",
+			content: `A="a\tb\nc \"q\" \\ \$"` + "\n" + `B=""` + "\n",
+			want:    []string{"A=a\tb\nc \"q\" \\ $", "B="},
+		},
+		{
+			name:    "multi-line value",
+			content: "A=\"line 1\nline 2\"\nB='x\n\ny'\nC=3\n",
+			want:    []string{"A=line 1\nline 2", "B=x\n\ny", "C=3"},
+		},
+		{
+			name:    "crlf",
+			content: "A=1\r\nB=\"x\"\r\n",
+			want:    []string{"A=1", "B=x"},
+		},
+		{
+			name:    "no trailing newline",
+			content: "A=1",
+			want:    []string{"A=1"},
+		},
+		{
+			name:    "unterminated quote",
+			content: "A=1\nB=\"open\nC=2\n",
+			wantErr: ":2: unterminated quote",
+		},
+		{
+			name:    "text after quote",
+			content: "A=\"x\" y\n",
+			wantErr: ":1: unexpected",
+		},
+		{
+			name:    "missing =",
+			content: "A=1\nB\n",
+			wantErr: ":2: want KEY=VAL",
+		},
+		{
+			name:    "space in key",
+			content: "A B=1\n",
+			wantErr: ":1: want KEY=VAL",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readEnvFile(writeTemp(t, ".env", tt.content))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,4 +2,11 @@ module github.com/ndsky1003/cmd/launch
 
 go 1.25.7
 
-require github.com/ndsky1003/cmd/common v1.0.0
+require (
+	github.com/BurntSushi/toml v1.6.0
+	github.com/creack/pty v1.1.24
+	github.com/ndsky1003/cmd/common v1.1.1
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require github.com/klauspost/compress v1.18.0 // indirect
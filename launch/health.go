@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// Probe 存活探针,HTTP、TCP、Exec 三选一.
+// 连续失败 Failures 次后 launch 会杀掉子进程并重启,不受重启策略限制,但仍计入 crash loop.
+type Probe struct {
+	// HTTP GET 的地址,例如 http://127.0.0.1:8080/healthz,2xx/3xx 视为健康
+	HTTP string `json:"http" yaml:"http"`
+	// TCP 连接的地址,例如 127.0.0.1:8080,连接成功视为健康
+	TCP string `json:"tcp" yaml:"tcp"`
+	// Exec 执行的命令及参数,退出码 0 视为健康
+	Exec []string `json:"exec" yaml:"exec"`
+
+	Interval Duration `json:"interval" yaml:"interval"` // 默认 10s
+	Timeout  Duration `json:"timeout" yaml:"timeout"`   // 默认 1s
+	Failures int      `json:"failures" yaml:"failures"` // 默认 3
+	// Delay 子进程启动后等待多久开始探测
+	Delay Duration `json:"delay" yaml:"delay"`
+}
+
+// validate 校验探针配置并补全默认值
+func (pr *Probe) validate() error {
+	n := 0
+	for _, set := range []bool{pr.HTTP != "", pr.TCP != "", len(pr.Exec) > 0} {
+		if set {
+			n++
+		}
+	}
+	if n != 1 {
+		return errors.New("probe needs exactly one of http, tcp, exec")
+	}
+	if pr.Interval <= 0 {
+		pr.Interval = Duration(10 * time.Second)
+	}
+	if pr.Timeout <= 0 {
+		pr.Timeout = Duration(time.Second)
+	}
+	if pr.Failures <= 0 {
+		pr.Failures = 3
+	}
+	return nil
+}
+
+// check 执行一次探测
+func (pr *Probe) check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(pr.Timeout))
+	defer cancel()
+	switch {
+	case pr.HTTP != "":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, pr.HTTP, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+			return fmt.Errorf("http status %d", resp.StatusCode)
+		}
+	case pr.TCP != "":
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", pr.TCP)
+		if err != nil {
+			return err
+		}
+		conn.Close()
+	default:
+		out, err := exec.CommandContext(ctx, pr.Exec[0], pr.Exec[1:]...).CombinedOutput()
+		if err != nil {
+			if out = bytes.TrimSpace(out); len(out) > 0 {
+				return fmt.Errorf("%v: %s", err, out[:min(len(out), 200)])
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// probe 在子进程运行期间按间隔探测,连续失败达到阈值后标记为不健康并终止子进程
+func (p *program) probe(ctx context.Context, pid int) {
+	pr := p.Probe
+	t := time.NewTimer(time.Duration(pr.Delay + pr.Interval))
+	defer t.Stop()
+	var history []string // 连续失败的记录
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		err := pr.check(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		t.Reset(time.Duration(pr.Interval))
+		if err == nil {
+			if len(history) > 0 {
+				slog.Info("liveness probe recovered", "name", p.Name, "pid", pid, "failures", len(history))
+				history = nil
+			}
+			continue
+		}
+		history = append(history, time.Now().Format(time.RFC3339)+" "+err.Error())
+		slog.Warn("liveness probe failed", "name", p.Name, "pid", pid, "failures", len(history), "threshold", pr.Failures, "err", err)
+		if len(history) < pr.Failures {
+			continue
+		}
+		slog.Error("liveness probe failure threshold reached, restarting process",
+			"name", p.Name,
+			"pid", pid,
+			"history", history,
+		)
+		p.mu.Lock()
+		p.unhealthy = true
+		p.mu.Unlock()
+		// 不响应 SIGTERM 的子进程正是探针要处理的情况,StopGrace 为 0 时也要 SIGKILL
+		grace := p.StopGrace
+		if grace <= 0 {
+			grace = defaultStopGrace
+		}
+		p.terminate(syscall.SIGTERM, grace)
+		return
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 行格式
+const (
+	lineFormatRaw  = ""     // 原样写入,不做按行处理
+	lineFormatText = "text" // 2006-01-02T15:04:05.000Z07:00 stdout[pid] line
+	lineFormatJSON = "json" // {"time":...,"stream":...,"pid":...,"line":...}
+)
+
+const (
+	lineTimeFormat    = "2006-01-02T15:04:05.000Z07:00"
+	defaultMaxLine    = 16 * 1024
+	lineTruncatedMark = " ...[truncated]"
+)
+
+// lineWriter 把子进程的输出按行缓冲,每个完整的行交给 emit 一次性输出,避免 stdout 与 stderr 的半行交错.
+// 超过 maxLine 的行会被截断,剩余部分丢弃到下一个换行为止.
+type lineWriter struct {
+	// emit 输出一行,line 不含换行和结尾的 \r,返回后会被复用;调用时持有 mu
+	emit    func(line []byte, pid int, truncated bool) error
+	maxLine int
+
+	mu         sync.Mutex
+	pid        int
+	buf        []byte
+	discarding bool // 当前行已截断输出,丢弃到换行为止
+}
+
+// newLineWriter 每行加上时间、流名称和 pid 后写入 w,截断的行加上 lineTruncatedMark
+func newLineWriter(w io.Writer, stream, format string, maxLine int, localTime bool) *lineWriter {
+	return newLineSplitter(maxLine, func(line []byte, pid int, truncated bool) error {
+		out, err := formatLine(line, stream, format, pid, truncated, localTime)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(out)
+		return err
+	})
+}
+
+func newLineSplitter(maxLine int, emit func(line []byte, pid int, truncated bool) error) *lineWriter {
+	if maxLine <= 0 {
+		maxLine = defaultMaxLine
+	}
+	return &lineWriter{emit: emit, maxLine: maxLine}
+}
+
+// setPid 子进程(重新)启动时更新 pid
+func (lw *lineWriter) setPid(pid int) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	lw.pid = pid
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if lw.discarding {
+			if i < 0 {
+				return n, nil
+			}
+			lw.discarding = false
+			p = p[i+1:]
+			continue
+		}
+		var chunk []byte
+		if i < 0 {
+			chunk, p = p, nil
+		} else {
+			chunk, p = p[:i], p[i+1:]
+		}
+		lw.buf = append(lw.buf, chunk...)
+		if len(lw.buf) > lw.maxLine {
+			err := lw.emitLine(lw.buf[:lw.maxLine], true)
+			lw.discarding = i < 0
+			if err != nil {
+				return n - len(p), err
+			}
+			continue
+		}
+		if i < 0 {
+			break
+		}
+		if err := lw.emitLine(lw.buf, false); err != nil {
+			return n - len(p), err
+		}
+	}
+	return n, nil
+}
+
+// flush 输出缓冲中未以换行结尾的最后一行,子进程退出后调用
+func (lw *lineWriter) flush() error {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	lw.discarding = false
+	if len(lw.buf) == 0 {
+		return nil
+	}
+	return lw.emitLine(lw.buf, false)
+}
+
+// emitLine 输出一行并清空 buf,调用方需持有 lw.mu
+func (lw *lineWriter) emitLine(line []byte, truncated bool) error {
+	err := lw.emit(bytes.TrimSuffix(line, []byte("\r")), lw.pid, truncated)
+	lw.buf = lw.buf[:0]
+	return err
+}
+
+// formatLine 按 format 格式化一行,结果以换行结尾
+func formatLine(line []byte, stream, format string, pid int, truncated, localTime bool) ([]byte, error) {
+	t := time.Now()
+	if !localTime {
+		t = t.UTC()
+	}
+	var out []byte
+	switch format {
+	case lineFormatJSON:
+		b, err := json.Marshal(struct {
+			Time      string `json:"time"`
+			Stream    string `json:"stream"`
+			Pid       int    `json:"pid"`
+			Line      string `json:"line"`
+			Truncated bool   `json:"truncated,omitempty"`
+		}{t.Format(lineTimeFormat), stream, pid, string(line), truncated})
+		if err != nil {
+			return nil, err
+		}
+		out = append(b, '\n')
+	default:
+		out = t.AppendFormat(out, lineTimeFormat)
+		out = append(out, ' ')
+		out = append(out, stream...)
+		out = append(out, '[')
+		out = strconv.AppendInt(out, int64(pid), 10)
+		out = append(out, "] "...)
+		out = append(out, line...)
+		if truncated {
+			out = append(out, lineTruncatedMark...)
+		}
+		out = append(out, '\n')
+	}
+	return out, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLineSplitter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   []string // 截断的行以 + 结尾
+	}{
+		{"lines", []string{"a\nb\n"}, []string{"a", "b"}},
+		{"split across writes", []string{"h", "ey\nyo", "u\n"}, []string{"hey", "you"}},
+		{"crlf", []string{"a\r\nb\r\n"}, []string{"a", "b"}},
+		{"empty lines", []string{"\n\n"}, []string{"", ""}},
+		{"trailing half line", []string{"a\nb"}, []string{"a", "b"}},
+		{"truncated", []string{"abcdefgh\nx\n"}, []string{"abcd+", "x"}},
+		{"truncated across writes", []string{"abc", "def", "gh", "ij\nx\n"}, []string{"abcd+", "x"}},
+		{"exactly max", []string{"abcd\n"}, []string{"abcd"}},
+		{"truncated at end", []string{"abcdefgh"}, []string{"abcd+"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			lw := newLineSplitter(4, func(line []byte, pid int, truncated bool) error {
+				if pid != 42 {
+					t.Errorf("pid = %d, want 42", pid)
+				}
+				s := string(line)
+				if truncated {
+					s += "+"
+				}
+				got = append(got, s)
+				return nil
+			})
+			lw.setPid(42)
+			for _, w := range tt.writes {
+				if n, err := lw.Write([]byte(w)); n != len(w) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v", w, n, err)
+				}
+			}
+			lw.flush()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lines = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineWriterText(t *testing.T) {
+	var b strings.Builder
+	lw := newLineWriter(&b, "stderr", lineFormatText, 4, false)
+	lw.setPid(7)
+	lw.Write([]byte("ok\nabcdefgh\n"))
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %q", b.String())
+	}
+	for i, want := range []string{" stderr[7] ok", " stderr[7] abcd" + lineTruncatedMark} {
+		if !strings.HasSuffix(lines[i], want) {
+			t.Errorf("line %d = %q, want suffix %q", i, lines[i], want)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/ndsky1003/cmd/common/logrotate"
+)
+
+// followInterval -f 检查日志文件新内容和轮转的间隔
+const followInterval = 250 * time.Millisecond
+
+// runLogs 按时间顺序输出日志的所有备份和当前文件,压缩的备份自动解压,返回退出码.
+// -since/-until 按备份文件名中的轮转时间筛选文件,-f 在输出完后继续跟踪当前文件,轮转后自动切换到新文件
+func runLogs(cfg *Config, args []string) int {
+	fs := flag.NewFlagSet("logs", flag.ExitOnError)
+	since := fs.String("since", "", "only files written after this time: 2006-01-02[ 15:04[:05]], RFC3339 or a duration ago like 2h")
+	until := fs.String("until", "", "only files written before this time, same formats as -since")
+	grep := fs.String("grep", "", "only lines matching this regular expression")
+	follow := fs.Bool("f", false, "keep printing new lines of the current log, across rotations")
+	stderr := fs.Bool("stderr", false, "read the separate stderr log instead")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: launch [-c config | -dir dir -filename name] logs [flags] [name]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	l, err := selectLog(cfg, fs.Arg(0), *stderr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	var from, to time.Time
+	if from, err = parseTime(*since); err != nil {
+		fmt.Fprintf(os.Stderr, "bad -since: %v\n", err)
+		return 2
+	}
+	if to, err = parseTime(*until); err != nil {
+		fmt.Fprintf(os.Stderr, "bad -until: %v\n", err)
+		return 2
+	}
+	if *follow && !to.IsZero() {
+		fmt.Fprintln(os.Stderr, "-f can't be used with -until")
+		return 2
+	}
+	lf := &lineFilter{w: bufio.NewWriterSize(os.Stdout, 64*1024)}
+	if *grep != "" {
+		if lf.re, err = regexp.Compile(*grep); err != nil {
+			fmt.Fprintf(os.Stderr, "bad -grep: %v\n", err)
+			return 2
+		}
+	}
+	defer lf.w.Flush()
+
+	backups, err := l.Backups()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	// 每个备份包含上一次轮转到它的轮转时间之间写入的内容
+	var start time.Time
+	for _, b := range backups {
+		begin := start
+		start = b.Time
+		if (!from.IsZero() && b.Time.Before(from)) || (!to.IsZero() && begin.After(to)) {
+			continue
+		}
+		if err := lf.copyBackup(b.Path); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", b.Path, err)
+		}
+	}
+	if !to.IsZero() && start.After(to) {
+		return 0
+	}
+	if err := lf.copyCurrent(l.Filename, *follow); err != nil {
+		lf.w.Flush()
+		fmt.Fprintf(os.Stderr, "%s: %v\n", l.Filename, err)
+		return 1
+	}
+	return 0
+}
+
+// selectLog 返回要读取的日志: 配置文件中名为 name 的子进程(launch 表示 launch 自身),
+// 只有一个子进程时 name 可以省略;没有配置文件时使用命令行参数指定的日志
+func selectLog(cfg *Config, name string, stderr bool) (*logrotate.Logger, error) {
+	if cfg == nil {
+		if stderr {
+			if errLogger.Filename == "" {
+				return nil, errors.New("-stderr needs -errfilename")
+			}
+			if errLogger.BackDir == "" {
+				errLogger.BackDir = logger.BackDir
+			}
+			return errLogger, nil
+		}
+		return logger, nil
+	}
+	if name == "launch" {
+		return cfg.Log, nil
+	}
+	if name == "" && len(cfg.Programs) > 1 {
+		return nil, errors.New("logs needs a program name")
+	}
+	for _, pc := range cfg.Programs {
+		if name != "" && pc.Name != name {
+			continue
+		}
+		if !stderr {
+			return pc.Log, nil
+		}
+		if pc.Stderr == nil {
+			return nil, fmt.Errorf("program %s has no separate stderr log", pc.Name)
+		}
+		return pc.Stderr, nil
+	}
+	return nil, fmt.Errorf("no such program: %s", name)
+}
+
+// parseTime 解析 -since/-until,空字符串返回零值
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown time format: %s", s)
+}
+
+// lineFilter 把匹配 re 的行写入 w,re 为空时输出所有行
+type lineFilter struct {
+	re *regexp.Regexp
+	w  *bufio.Writer
+}
+
+// feed 输出 r 中的完整行,返回读到 EOF 时未以换行结尾的部分,partial 是上次剩下的部分
+func (lf *lineFilter) feed(r io.Reader, partial []byte) ([]byte, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	for {
+		chunk, err := br.ReadSlice('\n')
+		partial = append(partial, chunk...)
+		switch err {
+		case nil:
+			if err := lf.emit(partial); err != nil {
+				return nil, err
+			}
+			partial = partial[:0]
+		case bufio.ErrBufferFull:
+		case io.EOF:
+			return partial, nil
+		default:
+			return partial, err
+		}
+	}
+}
+
+func (lf *lineFilter) emit(line []byte) error {
+	if len(line) == 0 || (lf.re != nil && !lf.re.Match(line)) {
+		return nil
+	}
+	if _, err := lf.w.Write(line); err != nil {
+		return err
+	}
+	if line[len(line)-1] != '\n' {
+		return lf.w.WriteByte('\n')
+	}
+	return nil
+}
+
+func (lf *lineFilter) copyBackup(path string) error {
+	r, err := logrotate.OpenBackup(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	partial, err := lf.feed(r, nil)
+	if err != nil {
+		return err
+	}
+	return lf.emit(partial)
+}
+
+// copyCurrent 输出当前日志文件;follow 时继续等待新内容,
+// 文件被轮转(换了 inode)时读完旧文件再打开新文件,被截断时从头读
+func (lf *lineFilter) copyCurrent(path string, follow bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if !follow || !os.IsNotExist(err) {
+			return err
+		}
+	}
+	var partial []byte
+	for {
+		if f != nil {
+			if partial, err = lf.feed(f, partial); err != nil {
+				return err
+			}
+		}
+		if !follow {
+			if f != nil {
+				f.Close()
+			}
+			return lf.emit(partial)
+		}
+		if err := lf.w.Flush(); err != nil {
+			return err
+		}
+		time.Sleep(followInterval)
+
+		cur, err := os.Stat(path)
+		if err != nil {
+			// 轮转时新文件还没创建
+			continue
+		}
+		if f != nil {
+			info, err := f.Stat()
+			if err != nil {
+				return err
+			}
+			if os.SameFile(cur, info) {
+				if off, err := f.Seek(0, io.SeekCurrent); err == nil && cur.Size() < off {
+					f.Seek(0, io.SeekStart)
+					partial = partial[:0]
+				}
+				continue
+			}
+			// 读完旧文件剩下的内容
+			if partial, err = lf.feed(f, partial); err != nil {
+				return err
+			}
+			if err := lf.emit(partial); err != nil {
+				return err
+			}
+			partial = partial[:0]
+			f.Close()
+		}
+		if f, err = os.Open(path); err != nil {
+			f = nil
+		}
+	}
+}
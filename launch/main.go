@@ -1,18 +1,23 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log/slog"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/ndsky1003/cmd/common/logrotate"
 	"github.com/ndsky1003/cmd/common/version"
 )
 
 var Version = "dev"
 
-var logger = &Logger{
+var logger = &logrotate.Logger{
 	BackDir:    "log",
 	Filename:   "main.log",
 	MaxSize:    100, // megabytes
@@ -21,61 +26,374 @@ var logger = &Logger{
 	Compress:   true,
 	LocalTime:  true,
 }
+
+// errLogger stderr 单独的日志,Filename 为空时 stderr 与 stdout 写入同一个 logger
+var errLogger = &logrotate.Logger{}
+
+// errMirror stderr 写入 errLogger 的同时也写入 logger
+var errMirror bool
+
 var sub_exe string
 
+// configFile 配置文件,设置后忽略单进程相关的参数,按配置启动多个子进程
+var configFile string
+
+// showVersion 为 true 时输出版本后退出 (-v,-version)
+var showVersion bool
+
+// sockPath 控制命令使用的 unix socket,为空不监听
+var sockPath string
+
+// metricsAddr /metrics 的监听地址,为空不监听
+var metricsAddr string
+
+var (
+	lineFormat string
+	maxLine    int
+)
+
+var restart = Restart{}
+
+var (
+	group     bool
+	stopGrace time.Duration
+)
+
+// probe 存活探针,-probehttp/-probetcp/-probeexec 都为空时不探测
+var (
+	probe     = &Probe{}
+	probeExec string
+
+	archiveCmd string
+
+	runUser   string
+	runGroups string
+	rlimits   Rlimits
+	nice      int
+	ionice    string
+	workDir   string
+
+	envs     []string
+	envFiles []string
+	clearEnv bool
+	envAllow string
+
+	usePty    bool
+	stripANSI bool
+
+	tee      bool
+	teeColor bool
+
+	syslogAddrs    []string
+	syslogFacility string
+	syslogTag      string
+)
+
 func init() {
-	flag.StringVar(&sub_exe, "r", "", "sub exe")
-	flag.StringVar(&logger.BackDir, "dir", "log", "log dir")
+	runShim()
+	flag.StringVar(&sub_exe, "r", "", "sub exe (deprecated,use: launch [flags] -- command [args])")
+	flag.StringVar(&configFile, "c", "", "config file (yaml|json|toml) describing multiple programs")
+	flag.StringVar(&sockPath, "sock", "", "unix socket for control commands (status|start|stop|restart|rotate|tail),empty disable")
+	flag.StringVar(&metricsAddr, "metrics", "", "listen address for prometheus /metrics,eg:127.0.0.1:9100,empty disable")
+	flag.StringVar(&logger.BackDir, "dir", "log", "backup dir,rotated backups are compressed and pruned here")
 	flag.StringVar(&logger.Filename, "filename", "main.log", "log name")
 	flag.IntVar(&logger.MaxSize, "maxsize", 100, "max size (M)")
-	flag.IntVar(&logger.MaxBackups, "maxbackups", 30, "max backups (数量)")
-	flag.IntVar(&logger.MaxAge, "maxage", 28, "max age (天)")
+	flag.IntVar(&logger.MaxBackups, "maxbackups", defaultMaxBackups, "max backups (数量),negative no limit")
+	flag.IntVar(&logger.MaxAge, "maxage", defaultMaxAge, "max age (天),negative no limit")
 	flag.BoolVar(&logger.Compress, "compress", false, "true compress,false no compress")
+	flag.StringVar(&logger.Compression, "compression", "", "backup compression: gzip|zstd|none,empty gzip when -compress")
+	flag.IntVar(&logger.CompressLevel, "compresslevel", 0, "compression level (gzip -2..9,zstd 1..22),0 codec default")
 	flag.BoolVar(&logger.LocalTime, "localtime", true, "true use localtime,false use utc")
-	v := flag.Bool("v", false, "print version information and exit")
-	flag.BoolVar(v, "version", false, "same as -v")
-	flag.Parse()
-	if *v {
-		fmt.Printf("launch version %s\n", version.GetVersion(Version))
-		os.Exit(0)
+	flag.IntVar(&logger.MaxTotalSize, "maxtotalsize", 0, "max size (M) of log file plus all backups,oldest backups removed first,0 no limit")
+	flag.BoolVar(&logger.Manifest, "manifest", false, "keep a manifest with size,line count and sha256 of each backup,check it with launch verify")
+	flag.IntVar(&logger.MinFreeSpace, "minfree", 0, "min free disk space (M) to keep,oldest backups removed first,0 no limit")
+	flag.StringVar(&logger.RotateEvery, "rotate", "", "also rotate at wall-clock boundaries: hourly|daily")
+	flag.StringVar(&archiveCmd, "archivehook", "", "command line run on each finished backup with its path as last argument,eg:upload to object storage")
+	flag.StringVar(&logger.Oversize, "oversize", logrotate.OversizeSplit, "a single write larger than -maxsize: split (across rotations)|truncate (with a marker)")
+	flag.StringVar(&errLogger.Filename, "errfilename", "", "stderr log name,empty write stderr to -filename")
+	flag.IntVar(&errLogger.MaxSize, "errmaxsize", 100, "stderr log max size (M)")
+	flag.IntVar(&errLogger.MaxBackups, "errmaxbackups", defaultMaxBackups, "stderr log max backups (数量),negative no limit")
+	flag.IntVar(&errLogger.MaxAge, "errmaxage", defaultMaxAge, "stderr log max age (天),negative no limit")
+	flag.StringVar(&lineFormat, "linefmt", lineFormatRaw, "prefix each output line with time, stream and pid: text|json,empty write raw output")
+	flag.IntVar(&maxLine, "maxline", defaultMaxLine, "max line length in bytes with -linefmt,longer lines are truncated")
+	flag.BoolVar(&errMirror, "errmirror", false, "also write stderr to -filename when -errfilename is set")
+	flag.StringVar((*string)(&restart.Policy), "restart", string(RestartNever), "restart policy: never|always|on-failure")
+	flag.DurationVar((*time.Duration)(&restart.Delay), "restartdelay", time.Second, "first restart delay, doubled on each restart")
+	flag.DurationVar((*time.Duration)(&restart.MaxDelay), "restartmaxdelay", time.Minute, "max restart delay")
+	flag.IntVar(&restart.MaxRestarts, "restartmax", defaultMaxRestarts, "max restarts in restartwindow before crash loop,negative no limit")
+	flag.DurationVar((*time.Duration)(&restart.Window), "restartwindow", time.Minute, "crash loop window")
+	flag.BoolVar(&group, "group", false, "forward signals to the child's whole process group")
+	flag.DurationVar(&stopGrace, "stopgrace", defaultStopGrace, "wait after forwarding stop signal before SIGKILL,negative wait forever")
+	flag.StringVar(&probe.HTTP, "probehttp", "", "liveness probe: http GET url,eg:http://127.0.0.1:8080/healthz")
+	flag.StringVar(&probe.TCP, "probetcp", "", "liveness probe: tcp connect address,eg:127.0.0.1:8080")
+	flag.StringVar(&probeExec, "probeexec", "", "liveness probe: command line,exit 0 means healthy")
+	flag.DurationVar((*time.Duration)(&probe.Interval), "probeinterval", 10*time.Second, "liveness probe interval")
+	flag.DurationVar((*time.Duration)(&probe.Timeout), "probetimeout", time.Second, "liveness probe timeout")
+	flag.IntVar(&probe.Failures, "probefailures", 3, "consecutive probe failures before restarting child")
+	flag.DurationVar((*time.Duration)(&probe.Delay), "probedelay", 0, "wait after child start before probing")
+	flag.BoolVar(&usr1Reopen, "usr1reopen", false, "on SIGUSR1 reopen log files instead of forwarding it to child (for external logrotate)")
+	flag.StringVar(&runUser, "user", "", "run child as user: name|uid[:group|gid],logs are still written by launch")
+	flag.StringVar(&runGroups, "groups", "", "child supplementary groups,comma separated,empty use the user's groups")
+	flag.StringVar(&rlimits.NoFile, "nofile", "", "child open files limit: n|soft:hard|unlimited")
+	flag.StringVar(&rlimits.Core, "core", "", "child core file size limit (bytes): n|soft:hard|unlimited")
+	flag.StringVar(&rlimits.AS, "as", "", "child address space limit (bytes): n|soft:hard|unlimited")
+	flag.IntVar(&nice, "nice", 0, "child nice value -20..19")
+	flag.StringVar(&ionice, "ionice", "", "child io priority: realtime|best-effort|idle[:level 0-7]")
+	flag.StringVar(&workDir, "workdir", "", "child working directory,empty use launch's")
+	flag.Func("env", "set child environment variable KEY=VAL,repeatable", func(s string) error {
+		envs = append(envs, s)
+		return nil
+	})
+	flag.Func("envfile", "load child environment from a dotenv file,repeatable", func(s string) error {
+		envFiles = append(envFiles, s)
+		return nil
+	})
+	flag.BoolVar(&clearEnv, "clearenv", false, "don't pass launch's environment to child except -envallow")
+	flag.StringVar(&envAllow, "envallow", "", "with -clearenv,variables still passed to child,comma separated,* wildcard,eg:PATH,LANG,LC_*")
+	flag.BoolVar(&tee, "tee", false, "also write child output to launch's stdout/stderr and pass launch's stdin to child")
+	flag.BoolVar(&teeColor, "teecolor", false, "with -tee,show child stderr in red")
+	flag.BoolVar(&usePty, "pty", false, "run child in a pseudo terminal,stdout and stderr are merged")
+	flag.BoolVar(&stripANSI, "stripansi", false, "strip ANSI escape sequences (colors,cursor moves) from child output")
+	flag.Func("syslog", "also send child output to syslog: unix:///dev/log|udp://host:514|tcp://host:514,repeatable", func(s string) error {
+		syslogAddrs = append(syslogAddrs, s)
+		return nil
+	})
+	flag.StringVar(&syslogFacility, "syslogfacility", "user", "syslog facility,stdout is sent as info and stderr as err")
+	flag.StringVar(&syslogTag, "syslogtag", "", "syslog app name,empty use program name")
+	flag.BoolVar(&showVersion, "v", false, "print version information and exit")
+	flag.BoolVar(&showVersion, "version", false, "same as -v")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), `usage:
+  launch [flags] [--] command [args]  run command,everything after -- is passed to it verbatim
+  launch [flags] -c config            run the programs in config
+  launch [-sock path] status|start|stop|restart|rotate|tail [name]
+  launch [-c config] verify|logs [name]
+flags:
+`)
+		flag.PrintDefaults()
 	}
 }
 
 func main() {
-	if sub_exe == "" {
-		slog.Info("子进程不能为空")
-		return
+	// 在 main 而不是 init 中解析,go test 的参数不会被当作 launch 的 flag
+	flag.Parse()
+	if showVersion {
+		fmt.Printf("launch version %s\n", version.GetVersion(Version))
+		os.Exit(0)
+	}
+	var (
+		cfg *Config
+		err error
+	)
+	if configFile != "" {
+		if cfg, err = loadConfig(configFile); err != nil {
+			slog.Error("load config failed", "config", configFile, "err", err)
+			os.Exit(2)
+		}
+		if cfg.Sock != "" {
+			sockPath = cfg.Sock
+		}
+		if cfg.Metrics != "" {
+			metricsAddr = cfg.Metrics
+		}
+	}
+	subcommand := subcommandOf(sub_exe, os.Args[1:], flag.Args())
+	// launch [-sock path] <command> [name]: 作为客户端控制正在运行的 launch
+	if isControlCommand(subcommand) {
+		os.Exit(runControl(sockPath, subcommand, flag.Args()[1:]))
+	}
+	switch subcommand {
+	case "verify":
+		// launch [-c config] verify [name]: 校验备份与 manifest 是否一致
+		os.Exit(runVerify(cfg, flag.Args()[1:]))
+	case "logs":
+		// launch [-c config] logs [name]: 按时间顺序输出日志及其备份
+		os.Exit(runLogs(cfg, flag.Args()[1:]))
 	}
-	if err := os.Mkdir(logger.BackDir, 0755); err != nil && !os.IsExist(err) {
+	deprecated := false
+	if cfg == nil {
+		var command string
+		var args []string
+		command, args, deprecated = childCommand(sub_exe, flag.Args())
+		if command == "" {
+			slog.Info("子进程不能为空")
+			flag.Usage()
+			os.Exit(2)
+		}
+		if deprecated {
+			fmt.Fprintf(os.Stderr, "launch: -r is deprecated, use: launch [flags] -- %s [args]\n", command)
+		}
+		// 单进程模式: launch 自身的日志与子进程写入同一个文件
+		pc := programFromFlags(command, args)
+		if err := pc.validate(); err != nil {
+			slog.Error("invalid flags", "err", err)
+			os.Exit(2)
+		}
+		cfg = &Config{Log: pc.Log, Programs: []*ProgramConfig{pc}}
+	}
+	if err := os.MkdirAll(cfg.Log.BackDir, 0755); err != nil {
 		panic(err)
 	}
-	slog.SetDefault(slog.New(slog.NewTextHandler(logger, nil)))
-	defer logger.Close()
-	launch()
+	slog.SetDefault(slog.New(slog.NewTextHandler(cfg.Log, nil)))
+	if deprecated {
+		slog.Warn("-r is deprecated, use: launch [flags] -- command [args]")
+	}
+	code, logs := launch(cfg)
+	// os.Exit 不会执行 defer,退出前手动关闭日志
+	for _, l := range logs {
+		l.Close()
+	}
+	os.Exit(code)
+}
+
+// afterDashDash 判断 flag 解析剩下的参数 rest 是否跟在 -- 之后
+func afterDashDash(args, rest []string) bool {
+	n := len(args) - len(rest)
+	return n > 0 && args[n-1] == "--"
+}
+
+// subcommandOf 返回 launch 的子命令,args 为全部命令行参数,rest 为 flag 解析剩下的参数.
+// 使用 -r 或 -- 之后的都是子进程的命令和参数,不作为 launch 的子命令
+func subcommandOf(r string, args, rest []string) string {
+	if r != "" || len(rest) == 0 || afterDashDash(args, rest) {
+		return ""
+	}
+	return rest[0]
 }
 
-func launch() {
-	exepath, err := exec.LookPath(sub_exe)
-	if err != nil {
-		slog.Error(err.Error())
-		return
+// childCommand 返回单进程模式的子进程命令和参数.
+// launch [flags] [--] command [args]: 第一个非 flag 参数是命令,之后的原样传给子进程;
+// 旧的 launch -r command [flags] [args] 仍然可用,此时 rest 全部是参数,deprecated 为 true.
+// launch 自己的 flag 不会传给子进程
+func childCommand(r string, rest []string) (command string, args []string, deprecated bool) {
+	if r != "" {
+		return r, rest, true
+	}
+	if len(rest) == 0 {
+		return "", nil, false
 	}
+	return rest[0], rest[1:], false
+}
 
-	args := os.Args[1:]
-	newArgs := make([]string, 0, len(args))
+// programFromFlags 根据命令行参数生成单进程模式的配置
+func programFromFlags(command string, args []string) *ProgramConfig {
+	pc := &ProgramConfig{
+		Name:       filepath.Base(command),
+		Command:    command,
+		Args:       args,
+		Log:        logger,
+		LineFormat: lineFormat,
+		MaxLine:    maxLine,
+		Restart:    restart,
+		Group:      group,
+		StopGrace:  Duration(stopGrace),
+		Dir:        workDir,
+		User:       runUser,
+		Rlimits:    rlimits,
+		Nice:       nice,
+		IONice:     ionice,
+		Env:        envs,
+		EnvFile:    envFiles,
+		ClearEnv:   clearEnv,
+		PTY:        usePty,
+		StripANSI:  stripANSI,
+		Tee:        tee,
+		TeeColor:   teeColor,
+		Stdin:      tee,
+	}
+	if envAllow != "" {
+		pc.EnvAllow = strings.Split(envAllow, ",")
+	}
+	for _, addr := range syslogAddrs {
+		pc.Syslog = append(pc.Syslog, &Syslog{Addr: addr, Facility: syslogFacility, Tag: syslogTag})
+	}
+	if runGroups != "" {
+		pc.Groups = strings.Split(runGroups, ",")
+	}
+	if errLogger.Filename != "" {
+		pc.Stderr = errLogger
+		pc.StderrMirror = errMirror
+	}
+	pc.ArchiveHook = strings.Fields(archiveCmd)
+	probe.Exec = strings.Fields(probeExec)
+	if probe.HTTP != "" || probe.TCP != "" || len(probe.Exec) > 0 {
+		pc.Probe = probe
+	}
+	return pc
+}
 
-	for i := 0; i < len(args); {
-		if args[i] == "-r" || args[i] == "--r" {
-			i += 2 // 跳过 -r 和它的值
+// launch 启动配置中的所有子进程并等待它们退出,
+// 返回 launch 的退出码以及需要在退出前关闭的日志
+func launch(cfg *Config) (int, []*logrotate.Logger) {
+	logs := []*logrotate.Logger{cfg.Log}
+	if len(cfg.ArchiveHook) > 0 {
+		cfg.Log.OnArchive = archiveHook("launch", cfg.ArchiveHook)
+	}
+	progs := make([]*program, len(cfg.Programs))
+	results := make([]exitStatus, len(cfg.Programs))
+	var running []*program
+	for i, pc := range cfg.Programs {
+		p, err := pc.build()
+		if err != nil {
+			results[i] = exitStatus{Code: -1, Err: err}
 			continue
 		}
-		newArgs = append(newArgs, args[i])
-		i++
+		progs[i] = p
+		running = append(running, p)
+		slog.Info("program environment", "name", p.Name, "env", maskEnv(p.Env))
+		for _, l := range p.logs {
+			if !slices.Contains(logs, l) {
+				logs = append(logs, l)
+			}
+		}
 	}
 
-	cmd := exec.Command(exepath, newArgs...)
-	cmd.Stdout = logger
-	cmd.Stderr = logger
-	err = cmd.Run()
-	slog.Info("process 正常 exit!", "exepath:", exepath, "err:", err, "args:", args, "newArgs:", newArgs)
+	for _, l := range logs {
+		// OnDrop 在 Logger 解锁后调用,写入 launch 自身的日志不会死锁
+		l.OnDrop = func(dropped int) {
+			slog.Warn("oversized log write truncated", "file", l.Filename, "dropped_bytes", dropped)
+		}
+	}
+	handleSignals(running, logs)
+	if sockPath != "" {
+		ln, err := serveControl(sockPath, running)
+		if err != nil {
+			slog.Error("control socket disabled", "sock", sockPath, "err", err)
+		} else {
+			defer ln.Close()
+		}
+	}
+	if metricsAddr != "" {
+		srv, err := serveMetrics(metricsAddr, running, logs)
+		if err != nil {
+			slog.Error("metrics disabled", "metrics", metricsAddr, "err", err)
+		} else {
+			defer srv.Close()
+		}
+	}
+	code := run(progs, results)
+	for _, p := range running {
+		for _, sk := range p.sinks {
+			sk.close(syslogDrainTimeout)
+		}
+	}
+	for i, st := range results {
+		pc := cfg.Programs[i]
+		level := slog.LevelInfo
+		if !st.success() {
+			level = slog.LevelError
+		}
+		slog.Log(context.Background(), level, "program exit",
+			"name", pc.Name,
+			"result", st.result(),
+			"exit_code", st.exitCode(),
+			"code", st.Code,
+			"signal", signalName(st.Signal),
+			"err", st.Err,
+			"command", pc.Command,
+			"args", pc.Args,
+		)
+	}
+	slog.Info("launch exit", "exit_code", code)
+	return code, logs
 }
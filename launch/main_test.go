@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// parseArgs 用与 launch 相同形式的 flag 解析 args,返回 -r 的值和剩下的参数
+func parseArgs(t *testing.T, args []string) (string, []string) {
+	t.Helper()
+	fs := flag.NewFlagSet("launch", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	r := fs.String("r", "", "")
+	fs.String("filename", "", "")
+	fs.Bool("group", false, "")
+	if err := fs.Parse(args); err != nil {
+		t.Fatalf("parse %q: %v", args, err)
+	}
+	return *r, fs.Args()
+}
+
+func TestCommandLine(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		dashDash   bool
+		subcommand string
+		command    string
+		childArgs  []string
+		deprecated bool
+	}{
+		{
+			name:     "dash dash",
+			args:     []string{"--", "sleep", "1"},
+			dashDash: true,
+			command:  "sleep", childArgs: []string{"1"},
+		},
+		{
+			name:       "no dash dash",
+			args:       []string{"sleep", "1"},
+			subcommand: "sleep",
+			command:    "sleep", childArgs: []string{"1"},
+		},
+		{
+			name:     "child flags after dash dash",
+			args:     []string{"--", "sh", "-c", "echo -- x"},
+			dashDash: true,
+			command:  "sh", childArgs: []string{"-c", "echo -- x"},
+		},
+		{
+			name:     "wrapper flags before dash dash",
+			args:     []string{"-group", "-filename", "app.log", "--", "app", "-group"},
+			dashDash: true,
+			command:  "app", childArgs: []string{"-group"},
+		},
+		{
+			name:    "-r value",
+			args:    []string{"-r", "app", "a", "b"},
+			command: "app", childArgs: []string{"a", "b"}, deprecated: true,
+		},
+		{
+			name:    "-r=value",
+			args:    []string{"-r=app", "a"},
+			command: "app", childArgs: []string{"a"}, deprecated: true,
+		},
+		{
+			name:     "-r with dash dash",
+			args:     []string{"-r", "app", "--", "-x", "y"},
+			dashDash: true,
+			command:  "app", childArgs: []string{"-x", "y"}, deprecated: true,
+		},
+		{
+			name:    "-r without args",
+			args:    []string{"-r", "app"},
+			command: "app", childArgs: []string{}, deprecated: true,
+		},
+		{
+			name:       "control subcommand",
+			args:       []string{"status", "web"},
+			subcommand: "status",
+			command:    "status", childArgs: []string{"web"},
+		},
+		{
+			name:     "command named like a subcommand",
+			args:     []string{"--", "status"},
+			dashDash: true,
+			command:  "status", childArgs: []string{},
+		},
+		{
+			name:     "flags then command named like a subcommand",
+			args:     []string{"-group", "--", "logs", "-f"},
+			dashDash: true,
+			command:  "logs", childArgs: []string{"-f"},
+		},
+		{
+			name: "empty",
+			args: []string{},
+		},
+		{
+			name:     "only dash dash",
+			args:     []string{"-group", "--"},
+			dashDash: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, rest := parseArgs(t, tt.args)
+			if got := afterDashDash(tt.args, rest); got != tt.dashDash {
+				t.Errorf("afterDashDash(%q, %q) = %v, want %v", tt.args, rest, got, tt.dashDash)
+			}
+			if got := subcommandOf(r, tt.args, rest); got != tt.subcommand {
+				t.Errorf("subcommandOf = %q, want %q", got, tt.subcommand)
+			}
+			command, args, deprecated := childCommand(r, rest)
+			if command != tt.command || deprecated != tt.deprecated {
+				t.Errorf("childCommand = %q, deprecated %v, want %q, deprecated %v",
+					command, deprecated, tt.command, tt.deprecated)
+			}
+			if len(args) != 0 || len(tt.childArgs) != 0 {
+				if !reflect.DeepEqual(args, tt.childArgs) {
+					t.Errorf("child args = %q, want %q", args, tt.childArgs)
+				}
+			}
+		})
+	}
+}
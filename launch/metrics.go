@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ndsky1003/cmd/common/logrotate"
+)
+
+// countWriter 统计写入的字节数和行数
+type countWriter struct {
+	w     io.Writer
+	bytes atomic.Int64
+	lines atomic.Int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.bytes.Add(int64(n))
+	c.lines.Add(int64(bytes.Count(p[:n], []byte{'\n'})))
+	return n, err
+}
+
+// serveMetrics 在 addr 上提供 prometheus 文本格式的 /metrics
+func serveMetrics(addr string, progs []*program, logs []*logrotate.Logger) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetrics(w, progs, logs)
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("metrics server failed", "addr", addr, "err", err)
+		}
+	}()
+	return srv, nil
+}
+
+// metric 一个指标及其所有样本
+type metric struct {
+	name, typ, help string
+	samples         []sample
+}
+
+type sample struct {
+	labels []string // key, value, key, value...
+	value  float64
+}
+
+func (m *metric) add(v float64, labels ...string) {
+	m.samples = append(m.samples, sample{labels, v})
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (m *metric) writeTo(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+	for _, s := range m.samples {
+		io.WriteString(w, m.name)
+		for i := 0; i+1 < len(s.labels); i += 2 {
+			sep := ","
+			if i == 0 {
+				sep = "{"
+			}
+			fmt.Fprintf(w, `%s%s="%s"`, sep, s.labels[i], labelEscaper.Replace(s.labels[i+1]))
+		}
+		if len(s.labels) > 0 {
+			io.WriteString(w, "}")
+		}
+		fmt.Fprintf(w, " %s\n", strconv.FormatFloat(s.value, 'g', -1, 64))
+	}
+}
+
+func writeMetrics(w io.Writer, progs []*program, logs []*logrotate.Logger) {
+	up := &metric{name: "launch_process_up", typ: "gauge", help: "Whether the child process is running."}
+	uptime := &metric{name: "launch_process_uptime_seconds", typ: "gauge", help: "Seconds since the running child process started."}
+	restarts := &metric{name: "launch_process_restarts_total", typ: "counter", help: "Number of times the child process was restarted."}
+	lastExit := &metric{name: "launch_process_last_exit_code", typ: "gauge", help: "Exit code of the last child exit, 128+signal if killed by a signal."}
+	cpu := &metric{name: "launch_process_cpu_seconds_total", typ: "counter", help: "User and system CPU time of the running child process."}
+	rss := &metric{name: "launch_process_resident_memory_bytes", typ: "gauge", help: "Resident memory size of the running child process."}
+	outBytes := &metric{name: "launch_output_bytes_total", typ: "counter", help: "Bytes written by the child process per stream."}
+	outLines := &metric{name: "launch_output_lines_total", typ: "counter", help: "Lines written by the child process per stream."}
+	for _, p := range progs {
+		p.mu.Lock()
+		pid, started, n, last := 0, p.started, p.restarts, p.last
+		if p.cmd != nil && p.cmd.Process != nil {
+			pid = p.cmd.Process.Pid
+		}
+		p.mu.Unlock()
+
+		name := []string{"name", p.Name}
+		restarts.add(float64(n), name...)
+		if last != nil {
+			lastExit.add(float64(last.exitCode()), name...)
+		}
+		if pid == 0 {
+			up.add(0, name...)
+		} else {
+			up.add(1, name...)
+			uptime.add(time.Since(started).Seconds(), name...)
+			if st, err := readProcStat(pid); err == nil {
+				cpu.add(st.cpu.Seconds(), name...)
+				rss.add(float64(st.rss), name...)
+			}
+		}
+		for _, stream := range []string{"stdout", "stderr"} {
+			if c := p.counters[stream]; c != nil {
+				outBytes.add(float64(c.bytes.Load()), "name", p.Name, "stream", stream)
+				outLines.add(float64(c.lines.Load()), "name", p.Name, "stream", stream)
+			}
+		}
+	}
+
+	rotations := &metric{name: "launch_log_rotations_total", typ: "counter", help: "Number of log file rotations."}
+	removed := &metric{name: "launch_log_backups_removed_total", typ: "counter", help: "Number of log backups removed by retention."}
+	compressed := &metric{name: "launch_log_backups_compressed_total", typ: "counter", help: "Number of log backups compressed."}
+	dropped := &metric{name: "launch_log_dropped_bytes_total", typ: "counter", help: "Bytes dropped by truncating oversized log writes."}
+	for _, l := range logs {
+		st := l.Stats()
+		rotations.add(float64(st.Rotations), "file", l.Filename)
+		removed.add(float64(st.Removed), "file", l.Filename)
+		compressed.add(float64(st.Compressed), "file", l.Filename)
+		dropped.add(float64(st.Dropped), "file", l.Filename)
+	}
+
+	syslogSent := &metric{name: "launch_syslog_sent_total", typ: "counter", help: "Lines sent to syslog."}
+	syslogDropped := &metric{name: "launch_syslog_dropped_total", typ: "counter", help: "Lines dropped because the syslog buffer was full or the sink failed."}
+	for _, p := range progs {
+		for _, sk := range p.sinks {
+			syslogSent.add(float64(sk.sent.Load()), "name", p.Name, "addr", sk.addr)
+			syslogDropped.add(float64(sk.dropped.Load()), "name", p.Name, "addr", sk.addr)
+		}
+	}
+
+	for _, m := range []*metric{up, uptime, restarts, lastExit, cpu, rss, outBytes, outLines, syslogSent, syslogDropped, rotations, removed, compressed, dropped} {
+		m.writeTo(w)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// credential 根据 user(name、uid、name:group 或 uid:gid)和附加组生成子进程的身份,
+// groups 为空时使用该用户所属的组.同时返回该用户的 HOME/USER/LOGNAME 环境变量
+func credential(spec string, groups []string) (*syscall.Credential, []string, error) {
+	name, group, _ := strings.Cut(spec, ":")
+	u, err := lookupUser(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, nil, fmt.Errorf("user %s: bad uid %s", name, u.Uid)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, nil, fmt.Errorf("user %s: bad gid %s", name, u.Gid)
+	}
+	if group != "" {
+		if gid, err = lookupGroup(group); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(groups) == 0 {
+		// 与登录时一样带上用户所属的组,查询失败时只使用主组
+		groups, _ = u.GroupIds()
+	}
+	cred := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	for _, g := range groups {
+		id, err := lookupGroup(g)
+		if err != nil {
+			return nil, nil, err
+		}
+		cred.Groups = append(cred.Groups, uint32(id))
+	}
+	env := []string{"USER=" + u.Username, "LOGNAME=" + u.Username}
+	if u.HomeDir != "" {
+		env = append(env, "HOME="+u.HomeDir)
+	}
+	return cred, env, nil
+}
+
+// lookupUser 按用户名或 uid 查找用户,/etc/passwd 中没有的 uid 也可以使用
+func lookupUser(name string) (*user.User, error) {
+	if u, err := user.Lookup(name); err == nil {
+		return u, nil
+	}
+	if _, err := strconv.ParseUint(name, 10, 32); err != nil {
+		return nil, fmt.Errorf("unknown user: %s", name)
+	}
+	if u, err := user.LookupId(name); err == nil {
+		return u, nil
+	}
+	return &user.User{Uid: name, Gid: name, Username: name}, nil
+}
+
+// lookupGroup 按组名或 gid 查找组
+func lookupGroup(name string) (uint64, error) {
+	if g, err := user.LookupGroup(name); err == nil {
+		return strconv.ParseUint(g.Gid, 10, 32)
+	}
+	if id, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return id, nil
+	}
+	return 0, fmt.Errorf("unknown group: %s", name)
+}
+
+// Rlimits 子进程的资源限制,为空表示继承 launch 的限制.
+// 取值为数字或 unlimited,同时设置软限制和硬限制,也可以写成 soft:hard
+type Rlimits struct {
+	NoFile string `json:"nofile" yaml:"nofile"` // 打开的文件数
+	Core   string `json:"core" yaml:"core"`     // core 文件大小(字节),0 禁止生成 core
+	AS     string `json:"as" yaml:"as"`         // 地址空间大小(字节)
+}
+
+func (r Rlimits) empty() bool {
+	return r.NoFile == "" && r.Core == "" && r.AS == ""
+}
+
+// rlimitValue 解析 n、unlimited 或 soft:hard
+func rlimitValue(s string) (cur, max uint64, err error) {
+	parse := func(v string) (uint64, error) {
+		if v == "unlimited" || v == "infinity" {
+			return rlimInfinity, nil
+		}
+		return strconv.ParseUint(v, 10, 64)
+	}
+	soft, hard, ok := strings.Cut(s, ":")
+	if cur, err = parse(soft); err != nil {
+		return 0, 0, fmt.Errorf("bad rlimit %q", s)
+	}
+	max = cur
+	if ok {
+		if max, err = parse(hard); err != nil {
+			return 0, 0, fmt.Errorf("bad rlimit %q", s)
+		}
+	}
+	if cur > max {
+		return 0, 0, fmt.Errorf("bad rlimit %q: soft limit above hard limit", s)
+	}
+	return cur, max, nil
+}
+
+// resources 在子进程启动后、执行目标程序之前设置的资源限制和优先级
+type resources struct {
+	rlimits []rlimit
+	nice    int
+	ioprio  int // ioprio_set 的值,0 表示不设置
+}
+
+type rlimit struct {
+	resource int
+	name     string
+	cur, max uint64
+}
+
+func (r *resources) empty() bool {
+	return r == nil || (len(r.rlimits) == 0 && r.nice == 0 && r.ioprio == 0)
+}
+
+// newResources 校验并解析资源限制,nice 为 -20 到 19,ionice 为 class[:level]
+func newResources(lim Rlimits, nice int, ionice string) (*resources, error) {
+	r := &resources{nice: nice}
+	if nice < -20 || nice > 19 {
+		return nil, fmt.Errorf("nice %d out of range -20..19", nice)
+	}
+	for _, l := range []struct {
+		name     string
+		value    string
+		resource int
+	}{
+		{"nofile", lim.NoFile, syscall.RLIMIT_NOFILE},
+		{"core", lim.Core, syscall.RLIMIT_CORE},
+		{"as", lim.AS, syscall.RLIMIT_AS},
+	} {
+		if l.value == "" {
+			continue
+		}
+		cur, max, err := rlimitValue(l.value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", l.name, err)
+		}
+		r.rlimits = append(r.rlimits, rlimit{resource: l.resource, name: l.name, cur: cur, max: max})
+	}
+	var err error
+	if r.ioprio, err = parseIONice(ionice); err != nil {
+		return nil, err
+	}
+	if !r.empty() && !resourcesSupported {
+		return nil, fmt.Errorf("rlimits, nice and ionice are not supported on this platform")
+	}
+	return r, nil
+}
+
+// ionice 的调度类型,与 ionice(1) 相同
+var ioClasses = map[string]int{"realtime": 1, "best-effort": 2, "idle": 3}
+
+// parseIONice 解析 class[:level],level 为 0(最高)到 7,idle 没有 level
+func parseIONice(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	name, lvl, ok := strings.Cut(s, ":")
+	class, found := ioClasses[name]
+	if !found {
+		return 0, fmt.Errorf("unknown ionice class: %s", name)
+	}
+	level := 4
+	if ok {
+		n, err := strconv.Atoi(lvl)
+		if err != nil || n < 0 || n > 7 {
+			return 0, fmt.Errorf("bad ionice level: %s", lvl)
+		}
+		level = n
+	}
+	if class == ioClasses["idle"] {
+		level = 0
+	}
+	return class<<13 | level, nil
+}
+
+// shimCredEnv 子进程的身份 uid:gid:groups,shim 设置好资源限制后自己降权再 exec.
+// shim 以 launch 的身份启动,目标用户没有权限执行 launch 本身时也能运行
+const shimCredEnv = "LAUNCH_EXEC_CRED"
+
+// formatCredential 把身份编码为 shimCredEnv 的值
+func formatCredential(c *syscall.Credential) string {
+	groups := make([]string, len(c.Groups))
+	for i, g := range c.Groups {
+		groups[i] = strconv.FormatUint(uint64(g), 10)
+	}
+	return fmt.Sprintf("%d:%d:%s", c.Uid, c.Gid, strings.Join(groups, ","))
+}
+
+// parseCredential 解析 formatCredential 的结果
+func parseCredential(s string) (*syscall.Credential, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("bad credential %q", s)
+	}
+	fields := parts[:2:2]
+	if parts[2] != "" {
+		fields = append(fields, strings.Split(parts[2], ",")...)
+	}
+	ids := make([]uint32, len(fields))
+	for i, f := range fields {
+		id, err := strconv.ParseUint(f, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad credential %q", s)
+		}
+		ids[i] = uint32(id)
+	}
+	return &syscall.Credential{Uid: ids[0], Gid: ids[1], Groups: ids[2:]}, nil
+}
+
+// shim 启动 shim 时使用的两个管道,见 runShim
+type shim struct {
+	sync  *os.File // 关闭后 shim 执行目标程序
+	ready *os.File // shim 初始化完成后写入一个字节
+	child []*os.File
+}
+
+// prepareShim 让 cmd 先以 launch 的身份运行 launch 自身,
+// 由它在资源限制设置好后切换到 cmd 的 Credential 并 exec path
+func prepareShim(cmd *exec.Cmd, path string) (*shim, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	syncR, syncW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		syncR.Close()
+		syncW.Close()
+		return nil, err
+	}
+	cmd.Path = self
+	cmd.ExtraFiles = []*os.File{syncR, readyW}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, shimEnv+"="+path)
+	if cred := cmd.SysProcAttr.Credential; cred != nil {
+		cmd.Env = append(cmd.Env, shimCredEnv+"="+formatCredential(cred))
+		cmd.SysProcAttr.Credential = nil
+	}
+	return &shim{sync: syncW, ready: readyR, child: []*os.File{syncR, readyW}}, nil
+}
+
+// release 等待 shim 就绪后在它上面设置资源限制,然后让它执行目标程序.
+// cmd.Start 失败时以 nil 调用,只关闭管道
+func (s *shim) release(pid int, r *resources) error {
+	for _, f := range s.child {
+		f.Close()
+	}
+	defer s.sync.Close()
+	defer s.ready.Close()
+	if r == nil {
+		return nil
+	}
+	if _, err := s.ready.Read(make([]byte, 1)); err != nil {
+		return fmt.Errorf("launch shim exited before setting resources: %w", err)
+	}
+	return r.apply(pid)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks /proc 中 cpu 时间的单位,linux 上 USER_HZ 固定为 100
+const clockTicks = 100
+
+// procStat 子进程的资源使用
+type procStat struct {
+	cpu time.Duration // user+system
+	rss int64         // bytes
+}
+
+// readProcStat 从 /proc/<pid>/stat 读取 cpu 时间和常驻内存
+func readProcStat(pid int) (procStat, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return procStat{}, err
+	}
+	// comm 可能包含空格,从最后一个 ')' 之后开始解析,fields[0] 为第 3 个字段 state
+	i := strings.LastIndexByte(string(data), ')')
+	if i < 0 {
+		return procStat{}, fmt.Errorf("bad /proc/%d/stat", pid)
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 22 {
+		return procStat{}, fmt.Errorf("bad /proc/%d/stat", pid)
+	}
+	utime, _ := strconv.ParseInt(fields[11], 10, 64)
+	stime, _ := strconv.ParseInt(fields[12], 10, 64)
+	rss, _ := strconv.ParseInt(fields[21], 10, 64)
+	return procStat{
+		cpu: time.Duration(utime+stime) * time.Second / clockTicks,
+		rss: rss * int64(os.Getpagesize()),
+	}, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+// ptyDrainTimeout 子进程退出后等待 pty 中剩余输出的时间,
+// 孙进程仍持有终端时不会读到 EOF,超时后不再等待
+const ptyDrainTimeout = time.Second
+
+// openPty 把 cmd 的 stdin/stdout/stderr 连接到新的伪终端,子进程成为新会话的首进程,
+// 以该终端为控制终端.子进程启动后调用方需关闭 tty,从 ptmx 读取输出
+func openPty(cmd *exec.Cmd) (ptmx, tty *os.File, err error) {
+	if ptmx, tty, err = pty.Open(); err != nil {
+		return nil, nil, err
+	}
+	// 较宽的终端,避免程序按 80 列折行
+	if err := pty.Setsize(ptmx, &pty.Winsize{Rows: 50, Cols: 200}); err != nil {
+		ptmx.Close()
+		tty.Close()
+		return nil, nil, err
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, tty
+	// 新会话的首进程同时也是进程组的首进程,Setpgid 不能与 Setsid 一起使用
+	cmd.SysProcAttr.Setpgid = false
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 0
+	return ptmx, tty, nil
+}
+
+// copyPty 把 ptmx 的输出写入 w,返回的 channel 在读完后关闭.
+// 子进程退出后 Linux 上读取会返回 EIO,视为结束
+func copyPty(w io.Writer, ptmx *os.File) chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		io.Copy(w, ptmx)
+	}()
+	return done
+}
+
+// drainPty 子进程退出后等待剩余的输出并关闭 ptmx
+func drainPty(ptmx *os.File, done chan struct{}) {
+	select {
+	case <-done:
+	case <-time.After(ptyDrainTimeout):
+	}
+	ptmx.Close()
+	<-done
+}
+
+// termFilter 处理终端输出: crlf 时把终端输出的 \r\n 还原为 \n,
+// ansi 时去掉 ANSI 转义序列(颜色、光标移动、窗口标题等)
+type termFilter struct {
+	w    io.Writer
+	crlf bool
+	ansi bool
+
+	mu    sync.Mutex
+	state int
+	cr    bool // 上一次写入以 \r 结尾,还不知道后面是否为 \n
+	out   []byte
+}
+
+// termFilter 解析 ANSI 转义序列的状态
+const (
+	termText      = iota
+	termEsc       // ESC 之后
+	termCSI       // ESC [ 之后,直到 0x40-0x7e 结束
+	termOSC       // ESC ] 之后,直到 BEL 或 ESC \ 结束
+	termOSCEsc    // OSC 中的 ESC
+	termEscInterm // ESC 加中间字符(0x20-0x2f)之后,直到结束字符
+)
+
+func (f *termFilter) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := f.out[:0]
+	for _, c := range p {
+		if f.ansi {
+			switch f.state {
+			case termEsc:
+				switch {
+				case c == '[':
+					f.state = termCSI
+				case c == ']':
+					f.state = termOSC
+				case c >= 0x20 && c <= 0x2f:
+					f.state = termEscInterm
+				default:
+					f.state = termText
+				}
+				continue
+			case termCSI:
+				if c >= 0x40 && c <= 0x7e {
+					f.state = termText
+				}
+				continue
+			case termOSC:
+				if c == 0x07 {
+					f.state = termText
+				} else if c == 0x1b {
+					f.state = termOSCEsc
+				}
+				continue
+			case termOSCEsc:
+				if c == '\\' {
+					f.state = termText
+				} else {
+					f.state = termOSC
+				}
+				continue
+			case termEscInterm:
+				if c < 0x20 || c > 0x2f {
+					f.state = termText
+				}
+				continue
+			}
+			if c == 0x1b {
+				f.state = termEsc
+				continue
+			}
+		}
+		if f.crlf {
+			if f.cr && c != '\n' {
+				out = append(out, '\r')
+			}
+			f.cr = c == '\r'
+			if f.cr {
+				continue
+			}
+		}
+		out = append(out, c)
+	}
+	f.out = out
+	if len(out) > 0 {
+		if _, err := f.w.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// flush 输出缓存的 \r,丢弃未结束的转义序列,子进程退出后调用
+func (f *termFilter) flush() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.state = termText
+	if !f.cr {
+		return nil
+	}
+	f.cr = false
+	_, err := f.w.Write([]byte{'\r'})
+	return err
+}
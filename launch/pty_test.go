@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTermFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		crlf, ansi bool
+		writes     []string
+		want       string
+	}{
+		{"pass through", false, false, []string{"a\r\n\x1b[31mb\x1b[0m\r"}, "a\r\n\x1b[31mb\x1b[0m\r"},
+		{"crlf", true, false, []string{"a\r\nb\r\n"}, "a\nb\n"},
+		{"crlf split across writes", true, false, []string{"a\r", "\nb\r", "\n"}, "a\nb\n"},
+		{"lone cr kept", true, false, []string{"50%\r", "100%\r\n"}, "50%\r100%\n"},
+		{"trailing cr flushed", true, false, []string{"a\r"}, "a\r"},
+		{"csi", false, true, []string{"\x1b[1;31mred\x1b[0m\n"}, "red\n"},
+		{"csi split across writes", false, true, []string{"a\x1b", "[1;", "31", "mb\x1b[", "0m"}, "ab"},
+		{"osc ended by bel", false, true, []string{"\x1b]0;title\x07a"}, "a"},
+		{"osc ended by esc backslash", false, true, []string{"\x1b]8;;http://x\x1b\\link\x1b]8;;\x1b\\"}, "link"},
+		{"osc split across writes", false, true, []string{"\x1b]0;ti", "tle\x1b", "\\a", "\x1b]2;x", "\x07b"}, "ab"},
+		{"esc inside osc", false, true, []string{"\x1b]0;a\x1bb\x07c"}, "c"},
+		{"two-byte escape", false, true, []string{"\x1b7a\x1b8b\x1b=c"}, "abc"},
+		{"charset escape", false, true, []string{"\x1b(Ba\x1b", "(", "0b"}, "ab"},
+		{"unterminated csi dropped", false, true, []string{"a\x1b[31"}, "a"},
+		{"unterminated osc dropped", false, true, []string{"a\x1b]0;title"}, "a"},
+		{"crlf and ansi", true, true, []string{"\x1b[32mok\x1b[0m\r", "\n\x1b[2K\rdone\r"}, "ok\n\rdone\r"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			f := &termFilter{w: &b, crlf: tt.crlf, ansi: tt.ansi}
+			for _, w := range tt.writes {
+				if n, err := f.Write([]byte(w)); n != len(w) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v", w, n, err)
+				}
+			}
+			if err := f.flush(); err != nil {
+				t.Fatal(err)
+			}
+			if got := b.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTermFilterFlushResets(t *testing.T) {
+	var b strings.Builder
+	f := &termFilter{w: &b, crlf: true, ansi: true}
+	f.Write([]byte("a\r\x1b]0;tit"))
+	if got := b.String(); got != "a" {
+		t.Fatalf("before flush got %q, want the \\r held back", got)
+	}
+	f.flush()
+	// flush 之后不再处于 OSC 中,也不再缓存 \r
+	f.Write([]byte("le\n"))
+	if got := b.String(); got != "a\rle\n" {
+		t.Errorf("got %q, want %q", got, "a\rle\n")
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	resourcesSupported = true
+	rlimInfinity       = ^uint64(0)
+
+	ioprioWhoProcess = 1
+
+	// shimEnv 非空时 launch 作为 shim 运行: 等待父进程设置好资源限制后执行该路径的程序
+	shimEnv = "LAUNCH_EXEC_PATH"
+)
+
+// apply 在 pid 上设置资源限制和优先级,launch 以 root 运行时可以提高硬限制
+func (r *resources) apply(pid int) error {
+	for _, l := range r.rlimits {
+		lim := struct{ Cur, Max uint64 }{l.cur, l.max}
+		_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(l.resource),
+			uintptr(unsafe.Pointer(&lim)), 0, 0, 0)
+		if errno != 0 {
+			return fmt.Errorf("set rlimit %s: %w", l.name, errno)
+		}
+	}
+	if r.nice != 0 {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, pid, r.nice); err != nil {
+			return fmt.Errorf("set nice: %w", err)
+		}
+	}
+	if r.ioprio != 0 {
+		_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(pid), uintptr(r.ioprio))
+		if errno != 0 {
+			return fmt.Errorf("set ionice: %w", errno)
+		}
+	}
+	return nil
+}
+
+// runShim 在 init 中最先调用.子进程需要资源限制时,launch 先以自身的身份启动自己作为 shim,
+// 父进程设置好 shim 的资源限制后关闭同步管道,shim 降权到子进程的身份后 exec 目标程序,
+// 这样目标程序从第一条指令开始就处于限制之下,且 root 仍可以为降权后的进程提高硬限制
+func runShim() {
+	path := os.Getenv(shimEnv)
+	if path == "" {
+		return
+	}
+	credSpec := os.Getenv(shimCredEnv)
+	os.Unsetenv(shimEnv)
+	os.Unsetenv(shimCredEnv)
+	// Go 运行时在 init 之前已经提高了 NOFILE 的软限制,此后才通过 fd 4 通知父进程设置,
+	// 避免父进程设置的值被覆盖;fd 3 在父进程设置完成后关闭
+	ready := os.NewFile(4, "ready")
+	ready.Write([]byte{0})
+	ready.Close()
+	sync := os.NewFile(3, "sync")
+	io.Copy(io.Discard, sync)
+	sync.Close()
+	// exec 前运行时会把 NOFILE 恢复成启动时的值;
+	// 重新设置一次当前值,让 exec 保留父进程设置的限制
+	var rl syscall.Rlimit
+	if syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl) == nil {
+		syscall.Setrlimit(syscall.RLIMIT_NOFILE, &rl)
+	}
+	if credSpec != "" {
+		if err := dropPrivileges(credSpec); err != nil {
+			fmt.Fprintf(os.Stderr, "launch: %v\n", err)
+			os.Exit(126)
+		}
+	}
+	err := syscall.Exec(path, os.Args, os.Environ())
+	fmt.Fprintf(os.Stderr, "launch: exec %s: %v\n", path, err)
+	if err == syscall.EACCES || err == syscall.ENOEXEC {
+		os.Exit(126)
+	}
+	os.Exit(127)
+}
+
+// dropPrivileges 与 exec.Cmd 的 Credential 相同: 依次设置附加组、gid 和 uid
+func dropPrivileges(spec string) error {
+	cred, err := parseCredential(spec)
+	if err != nil {
+		return err
+	}
+	groups := make([]int, len(cred.Groups))
+	for i, g := range cred.Groups {
+		groups[i] = int(g)
+	}
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("setgroups: %w", err)
+	}
+	if err := syscall.Setresgid(int(cred.Gid), int(cred.Gid), int(cred.Gid)); err != nil {
+		return fmt.Errorf("setresgid %d: %w", cred.Gid, err)
+	}
+	if err := syscall.Setresuid(int(cred.Uid), int(cred.Uid), int(cred.Uid)); err != nil {
+		return fmt.Errorf("setresuid %d: %w", cred.Uid, err)
+	}
+	return nil
+}
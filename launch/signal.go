@@ -0,0 +1,65 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ndsky1003/cmd/common/logrotate"
+)
+
+// forwardSignals launch 会接管并转发给子进程的信号
+var forwardSignals = []os.Signal{
+	syscall.SIGTERM,
+	syscall.SIGINT,
+	syscall.SIGQUIT,
+	syscall.SIGUSR1,
+	syscall.SIGUSR2,
+}
+
+// usr1Reopen 为 true 时 SIGUSR1 用于重新打开日志文件,不再转发给子进程
+var usr1Reopen bool
+
+// handleSignals 把收到的信号转发给所有子进程;
+// SIGTERM/SIGINT/SIGQUIT 同时会停止重启,并在宽限期后 SIGKILL 子进程.
+// SIGHUP 轮转日志,usr1Reopen 时 SIGUSR1 重新打开日志,二者都不会影响子进程.
+func handleSignals(progs []*program, logs []*logrotate.Logger) {
+	ch := make(chan os.Signal, 8)
+	signal.Notify(ch, append(forwardSignals, syscall.SIGHUP)...)
+	go func() {
+		for sig := range ch {
+			s := sig.(syscall.Signal)
+			switch {
+			case s == syscall.SIGTERM, s == syscall.SIGINT, s == syscall.SIGQUIT:
+				for _, p := range progs {
+					slog.Info("received signal, stopping process", "signal", signalName(s), "name", p.Name)
+					p.stop(s)
+				}
+			case s == syscall.SIGHUP:
+				for _, l := range logs {
+					if err := l.Rotate(); err != nil {
+						slog.Error("rotate log failed", "filename", l.Filename, "err", err)
+						continue
+					}
+					slog.Info("log rotated", "signal", signalName(s), "filename", l.Filename)
+				}
+			case s == syscall.SIGUSR1 && usr1Reopen:
+				for _, l := range logs {
+					if err := l.Reopen(); err != nil {
+						slog.Error("reopen log failed", "filename", l.Filename, "err", err)
+						continue
+					}
+					slog.Info("log reopened", "signal", signalName(s), "filename", l.Filename)
+				}
+			default:
+				for _, p := range progs {
+					slog.Info("forward signal", "signal", signalName(s), "name", p.Name)
+					if err := p.signal(s); err != nil {
+						slog.Error("forward signal failed", "signal", signalName(s), "name", p.Name, "err", err)
+					}
+				}
+			}
+		}
+	}()
+}
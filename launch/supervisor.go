@@ -0,0 +1,642 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ndsky1003/cmd/common/logrotate"
+)
+
+// RestartPolicy 子进程退出后的重启策略
+type RestartPolicy string
+
+const (
+	RestartNever     RestartPolicy = "never"      // 从不重启
+	RestartAlways    RestartPolicy = "always"     // 无论如何退出都重启
+	RestartOnFailure RestartPolicy = "on-failure" // 非 0 退出、被信号杀死或启动失败时重启
+)
+
+func (p RestartPolicy) valid() bool {
+	switch p {
+	case RestartNever, RestartAlways, RestartOnFailure:
+		return true
+	}
+	return false
+}
+
+// 命令行参数和配置文件共用的默认值,值为 0 时使用,负数表示不限制
+const (
+	defaultStopGrace   = 10 * time.Second
+	defaultMaxRestarts = 5
+	defaultMaxBackups  = 30
+	defaultMaxAge      = 28 // 天
+)
+
+// Restart 重启配置
+type Restart struct {
+	Policy RestartPolicy `json:"policy" yaml:"policy"`
+	// 第一次重启前的等待时间,之后每次翻倍,直到 MaxDelay
+	Delay    Duration `json:"delay" yaml:"delay"`
+	MaxDelay Duration `json:"maxdelay" yaml:"maxdelay"`
+	// Window 内重启超过 MaxRestarts 次视为 crash loop,不再重启;为 0 时使用默认值 5,负数表示不限制.
+	// 子进程连续运行超过 Window 则认为已稳定,退避时间重新从 Delay 开始.
+	MaxRestarts int      `json:"maxrestarts" yaml:"maxrestarts"`
+	Window      Duration `json:"window" yaml:"window"`
+}
+
+// shouldRestart 根据策略判断本次退出后是否需要重启
+func (r *Restart) shouldRestart(st exitStatus) bool {
+	switch r.Policy {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return !st.success()
+	}
+	return false
+}
+
+// exitStatus 一次运行的退出信息
+type exitStatus struct {
+	Code   int            // 退出码,被信号杀死时为 -1
+	Signal syscall.Signal // 导致退出的信号,0 表示正常退出
+	Err    error          // 启动失败等非退出类错误
+}
+
+func (s exitStatus) success() bool {
+	return s.Err == nil && s.Code == 0 && s.Signal == 0
+}
+
+// 退出结果分类
+const (
+	resultClean       = "clean"        // 退出码 0
+	resultNonZero     = "nonzero"      // 退出码非 0
+	resultSignaled    = "signaled"     // 被信号杀死
+	resultStartFailed = "start_failed" // 没能启动,例如找不到可执行文件
+)
+
+func (s exitStatus) result() string {
+	switch {
+	case s.Signal != 0:
+		return resultSignaled
+	case s.Err != nil && s.Code < 0:
+		return resultStartFailed
+	case s.Code != 0:
+		return resultNonZero
+	}
+	return resultClean
+}
+
+// exitCode 返回 launch 自身应使用的退出码,遵循 shell 的约定:
+// 被信号杀死为 128+signal,找不到可执行文件为 127,无法执行为 126
+func (s exitStatus) exitCode() int {
+	switch s.result() {
+	case resultSignaled:
+		return 128 + int(s.Signal)
+	case resultStartFailed:
+		if errors.Is(s.Err, exec.ErrNotFound) || errors.Is(s.Err, fs.ErrNotExist) {
+			return 127
+		}
+		return 126
+	}
+	return s.Code
+}
+
+// exitStatusOf 从 cmd.Wait 的结果中提取退出信息
+func exitStatusOf(cmd *exec.Cmd, err error) exitStatus {
+	ps := cmd.ProcessState
+	if ps == nil {
+		return exitStatus{Code: -1, Err: err}
+	}
+	st := exitStatus{Code: ps.ExitCode()}
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		st.Signal = ws.Signal()
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		st.Err = err
+	}
+	return st
+}
+
+// 子进程状态
+const (
+	stateRunning   = "running"   // 运行中
+	stateBackoff   = "backoff"   // 等待重启
+	stateStopped   = "stopped"   // 被控制命令停止,等待 start
+	stateExited    = "exited"    // 按重启策略不再重启
+	stateCrashLoop = "crashloop" // 重启过于频繁,不再重启
+)
+
+// outputWaitDelay 子进程退出后等待输出管道关闭的最长时间
+const outputWaitDelay = 2 * time.Second
+
+// program 一个受 launch 管理的子进程
+type program struct {
+	Name    string
+	Path    string
+	Args    []string
+	Env     []string // 完整的环境变量,nil 表示继承 launch 的环境变量
+	Dir     string
+	Restart Restart
+	Stdout  io.Writer
+	Stderr  io.Writer
+	// Group 为 true 时转发的信号发送给子进程的整个进程组
+	Group bool
+	// StopGrace 转发退出信号后等待子进程退出的时间,超时发送 SIGKILL;不大于 0 表示一直等待
+	StopGrace time.Duration
+	// Probe 存活探针,为 nil 不探测
+	Probe *Probe
+	// Credential 非空时子进程以该身份运行
+	Credential *syscall.Credential
+	// Resources 子进程的资源限制和优先级,为空时直接启动子进程,否则经 shim 启动
+	Resources *resources
+	// PTY 为 true 时 stdout 和 stderr 连接到同一个伪终端,输出都写入 Stdout
+	PTY bool
+	// Stdin 为 true 时子进程读取 launch 的 stdin
+	Stdin bool
+
+	logs []*logrotate.Logger // 子进程的日志,用于轮转和退出时关闭
+	tap  *broadcast          // 子进程输出的旁路,供 launch tail 使用
+	// lines 按行格式化或发送到 syslog 的 writer,子进程启动时更新 pid,退出时输出最后半行
+	lines []*lineWriter
+	sinks []*syslogSink
+	// filters 终端输出的过滤,子进程退出时输出缓存的内容
+	filters []*termFilter
+	// ptyIn 当前子进程的 pty,PTY 模式下 stdin 转发到这里
+	ptyIn     *os.File
+	stdinOnce sync.Once
+	// 每个输出流的写入统计,key 为 stdout/stderr
+	counters map[string]*countWriter
+
+	mu         sync.Mutex
+	cmd        *exec.Cmd // 当前运行中的子进程
+	state      string
+	started    time.Time // 当前子进程的启动时间
+	restarts   int
+	last       *exitStatus // 上一次退出的信息
+	held       bool        // 被控制命令停止
+	restartNow bool        // 控制命令要求立即重启
+	unhealthy  bool        // 存活探针失败导致的退出
+	wake       chan struct{}
+	stopping   bool // launch 正在退出
+	stopCh     chan struct{}
+}
+
+// stopped 返回在 stop 被调用后关闭的 channel
+func (p *program) stopped() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopCh == nil {
+		p.stopCh = make(chan struct{})
+	}
+	return p.stopCh
+}
+
+// woken 返回控制命令唤醒 supervise 用的 channel
+func (p *program) woken() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.wake == nil {
+		p.wake = make(chan struct{}, 1)
+	}
+	return p.wake
+}
+
+// notify 唤醒等待中的 supervise,调用方需持有 p.mu
+func (p *program) notify() {
+	if p.wake == nil {
+		p.wake = make(chan struct{}, 1)
+	}
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *program) setState(state string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.state = state
+}
+
+// signal 向当前运行的子进程(或其进程组)发送信号
+func (p *program) signal(sig syscall.Signal) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd == nil || p.cmd.Process == nil {
+		return nil
+	}
+	pid := p.cmd.Process.Pid
+	if p.Group {
+		pid = -pid
+	}
+	return syscall.Kill(pid, sig)
+}
+
+// ownGroup 子进程是否有独立的进程组.读取 launch 终端的子进程留在 launch 的进程组,
+// 否则在后台进程组中读终端会被 SIGTTIN 停止
+func (p *program) ownGroup() bool {
+	return p.Group || p.PTY || !p.Stdin
+}
+
+// kill 向子进程的整个进程组发送 SIGKILL,子进程已退出而孙进程仍持有输出管道时也能结束
+func (p *program) kill(cmd *exec.Cmd) error {
+	pid := cmd.Process.Pid
+	if p.ownGroup() {
+		pid = -pid
+	}
+	if err := syscall.Kill(pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		return err
+	}
+	return nil
+}
+
+// terminate 向当前子进程发送 sig,grace 后仍未退出则 SIGKILL;grace 为 0 表示一直等待
+func (p *program) terminate(sig syscall.Signal, grace time.Duration) {
+	p.mu.Lock()
+	cmd := p.cmd
+	p.mu.Unlock()
+	if cmd == nil {
+		return
+	}
+	if err := p.signal(sig); err != nil {
+		slog.Error("signal process failed", "name", p.Name, "signal", signalName(sig), "err", err)
+	}
+	if grace <= 0 {
+		return
+	}
+	time.AfterFunc(grace, func() {
+		p.mu.Lock()
+		running := p.cmd == cmd
+		p.mu.Unlock()
+		if !running {
+			return
+		}
+		slog.Warn("process did not exit in time, killing", "name", p.Name, "grace", grace.String())
+		if err := p.kill(cmd); err != nil {
+			slog.Error("kill process failed", "name", p.Name, "err", err)
+		}
+	})
+}
+
+// stop launch 退出时调用: 停止重启并把 sig 转发给子进程
+func (p *program) stop(sig syscall.Signal) {
+	p.mu.Lock()
+	if p.stopping {
+		p.mu.Unlock()
+		if err := p.signal(sig); err != nil {
+			slog.Error("signal process failed", "name", p.Name, "signal", signalName(sig), "err", err)
+		}
+		return
+	}
+	p.stopping = true
+	if p.stopCh == nil {
+		p.stopCh = make(chan struct{})
+	}
+	close(p.stopCh)
+	p.mu.Unlock()
+	p.terminate(sig, p.StopGrace)
+}
+
+// errProgramDone supervise 已经结束,无法再控制
+var errProgramDone = errors.New("program has exited")
+
+// ctlStop 控制命令 stop: 停止子进程但不退出 supervise,等待 ctlStart
+func (p *program) ctlStop() error {
+	p.mu.Lock()
+	if p.done() {
+		p.mu.Unlock()
+		return errProgramDone
+	}
+	p.held = true
+	p.restartNow = false
+	p.notify()
+	p.mu.Unlock()
+	p.terminate(syscall.SIGTERM, p.StopGrace)
+	return nil
+}
+
+// ctlStart 控制命令 start: 启动被 ctlStop 停止的子进程
+func (p *program) ctlStart() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.done() {
+		return errProgramDone
+	}
+	if !p.held {
+		return fmt.Errorf("program is %s", p.state)
+	}
+	p.held = false
+	p.notify()
+	return nil
+}
+
+// ctlRestart 控制命令 restart: 停止当前子进程(如果在运行)并立即重新启动
+func (p *program) ctlRestart() error {
+	p.mu.Lock()
+	if p.done() {
+		p.mu.Unlock()
+		return errProgramDone
+	}
+	p.held = false
+	p.restartNow = true
+	p.notify()
+	p.mu.Unlock()
+	p.terminate(syscall.SIGTERM, p.StopGrace)
+	return nil
+}
+
+// done supervise 是否已经结束,调用方需持有 p.mu
+func (p *program) done() bool {
+	return p.stopping || p.state == stateExited || p.state == stateCrashLoop
+}
+
+// takeRestart 取出控制命令的立即重启请求
+func (p *program) takeRestart() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r := p.restartNow
+	p.restartNow = false
+	return r
+}
+
+// hold 被控制命令停止后等待 start/restart,launch 退出时返回 false
+func (p *program) hold() bool {
+	for {
+		p.mu.Lock()
+		if !p.held {
+			p.restartNow = false
+			p.mu.Unlock()
+			return true
+		}
+		p.state = stateStopped
+		p.mu.Unlock()
+		select {
+		case <-p.woken():
+		case <-p.stopped():
+			return false
+		}
+	}
+}
+
+// runOnce 启动子进程并等待其退出
+func (p *program) runOnce(attempt int) (exitStatus, time.Duration) {
+	cmd := exec.Command(p.Path, p.Args...)
+	cmd.Dir = p.Dir
+	cmd.Env = p.Env
+	cmd.Stdout = p.Stdout
+	cmd.Stderr = p.Stderr
+	// 子进程退出后最多再等 outputWaitDelay 读完输出,脱离进程组的孙进程持有管道时 Wait 也会返回
+	cmd.WaitDelay = outputWaitDelay
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: p.ownGroup(), Credential: p.Credential}
+	var (
+		sh        *shim
+		ptmx, tty *os.File
+	)
+	// 启动失败时释放 shim 和 pty
+	fail := func(err error) (exitStatus, time.Duration) {
+		if sh != nil {
+			sh.release(0, nil)
+		}
+		if ptmx != nil {
+			ptmx.Close()
+			tty.Close()
+		}
+		return exitStatus{Code: -1, Err: err}, 0
+	}
+	if p.PTY {
+		var err error
+		if ptmx, tty, err = openPty(cmd); err != nil {
+			return fail(err)
+		}
+	}
+	if p.Stdin && ptmx == nil {
+		cmd.Stdin = os.Stdin
+	}
+	if !p.Resources.empty() {
+		var err error
+		if sh, err = prepareShim(cmd, p.Path); err != nil {
+			return fail(err)
+		}
+	}
+	start := time.Now()
+	p.mu.Lock()
+	if p.stopping {
+		p.mu.Unlock()
+		return fail(errors.New("process stopped before start"))
+	}
+	if err := cmd.Start(); err != nil {
+		p.mu.Unlock()
+		return fail(err)
+	}
+	var ptyDone chan struct{}
+	if ptmx != nil {
+		// 只有子进程持有 tty,子进程退出后读取 ptmx 才会结束
+		tty.Close()
+		ptyDone = copyPty(p.Stdout, ptmx)
+	}
+	if sh != nil {
+		if err := sh.release(cmd.Process.Pid, p.Resources); err != nil {
+			p.mu.Unlock()
+			cmd.Process.Kill()
+			cmd.Wait()
+			if ptmx != nil {
+				drainPty(ptmx, ptyDone)
+			}
+			return exitStatus{Code: -1, Err: err}, 0
+		}
+	}
+	p.cmd = cmd
+	p.ptyIn = ptmx
+	p.state = stateRunning
+	p.started = start
+	p.unhealthy = false
+	p.mu.Unlock()
+	if p.Stdin && ptmx != nil {
+		p.stdinOnce.Do(func() { go p.pumpStdin() })
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if p.Probe != nil {
+		go p.probe(ctx, cmd.Process.Pid)
+	}
+	for _, lw := range p.lines {
+		lw.setPid(cmd.Process.Pid)
+	}
+	slog.Info("process started", "name", p.Name, "pid", cmd.Process.Pid, "attempt", attempt, "path", p.Path, "args", p.Args)
+	err := cmd.Wait()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		// 子进程本身已正常退出,只是孙进程还持有输出管道
+		slog.Warn("output still held open after process exit, stop reading", "name", p.Name, "wait", outputWaitDelay.String())
+		err = nil
+	}
+	st := exitStatusOf(cmd, err)
+	cancel()
+	if ptmx != nil {
+		p.mu.Lock()
+		p.ptyIn = nil
+		p.mu.Unlock()
+		drainPty(ptmx, ptyDone)
+	}
+	for _, f := range p.filters {
+		f.flush()
+	}
+	for _, lw := range p.lines {
+		lw.flush()
+	}
+	p.mu.Lock()
+	p.cmd = nil
+	p.last = &st
+	p.mu.Unlock()
+	return st, time.Since(start)
+}
+
+// run 同时运行所有 program,全部退出后返回 launch 的退出码:
+// 所有子进程都正常退出时为 0,否则为第一个失败的子进程的退出码
+func run(progs []*program, results []exitStatus) int {
+	var wg sync.WaitGroup
+	for i, p := range progs {
+		if p == nil {
+			continue
+		}
+		wg.Go(func() {
+			results[i] = p.supervise()
+		})
+	}
+	wg.Wait()
+	code := 0
+	for _, st := range results {
+		if !st.success() {
+			code = st.exitCode()
+			break
+		}
+	}
+	return code
+}
+
+// supervise 按重启策略运行子进程,直到不再需要重启,返回最后一次的退出信息
+func (p *program) supervise() exitStatus {
+	r := p.Restart
+	first, maxDelay, window := time.Duration(r.Delay), time.Duration(r.MaxDelay), time.Duration(r.Window)
+	delay := first
+	var history []time.Time // Window 内的重启时间
+	for attempt := 1; ; attempt++ {
+		st, uptime := p.runOnce(attempt)
+		p.mu.Lock()
+		restarts := p.restarts
+		p.mu.Unlock()
+		slog.Info("process exit",
+			"name", p.Name,
+			"attempt", attempt,
+			"result", st.result(),
+			"code", st.Code,
+			"signal", signalName(st.Signal),
+			"uptime", uptime.Round(time.Millisecond).String(),
+			"restarts", restarts,
+			"err", st.Err,
+		)
+
+		select {
+		case <-p.stopped():
+			p.setState(stateExited)
+			return st
+		default:
+		}
+		// 丢弃子进程运行期间的唤醒,控制命令的状态已记录在 held/restartNow 中
+		select {
+		case <-p.woken():
+		default:
+		}
+		// 控制命令 stop/restart 触发的退出不受重启策略和 crash loop 限制
+		p.mu.Lock()
+		held := p.held
+		p.mu.Unlock()
+		if held {
+			if !p.hold() {
+				p.setState(stateExited)
+				return st
+			}
+			delay, history = first, nil
+			continue
+		}
+		if p.takeRestart() {
+			p.mu.Lock()
+			p.restarts++
+			p.mu.Unlock()
+			slog.Info("process restarting by control command", "name", p.Name, "attempt", attempt+1)
+			delay, history = first, nil
+			continue
+		}
+		p.mu.Lock()
+		unhealthy := p.unhealthy
+		p.mu.Unlock()
+		if !r.shouldRestart(st) && !unhealthy {
+			p.setState(stateExited)
+			return st
+		}
+
+		now := time.Now()
+		if window > 0 && uptime >= window {
+			delay = first
+		}
+		kept := history[:0]
+		for _, t := range history {
+			if window <= 0 || now.Sub(t) < window {
+				kept = append(kept, t)
+			}
+		}
+		history = append(kept, now)
+		if r.MaxRestarts > 0 && len(history) > r.MaxRestarts {
+			slog.Error("process crash loop, give up restarting",
+				"name", p.Name,
+				"restarts", len(history)-1,
+				"window", window.String(),
+				"code", st.Code,
+				"signal", signalName(st.Signal),
+			)
+			p.setState(stateCrashLoop)
+			return st
+		}
+
+		p.mu.Lock()
+		p.restarts++
+		p.state = stateBackoff
+		p.mu.Unlock()
+		slog.Warn("process restarting", "name", p.Name, "attempt", attempt+1, "delay", delay.String())
+		select {
+		case <-time.After(delay):
+		case <-p.woken():
+			// 等待期间收到 stop 则等待 start,收到 restart 则立即启动
+			if !p.hold() {
+				p.setState(stateExited)
+				return st
+			}
+			p.takeRestart()
+			delay, history = first, nil
+			continue
+		case <-p.stopped():
+			slog.Info("process stopped while waiting to restart", "name", p.Name)
+			p.setState(stateExited)
+			return st
+		}
+		if delay *= 2; maxDelay > 0 && delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+}
+
+func signalName(sig syscall.Signal) string {
+	if sig == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s(%d)", sig, int(sig))
+}
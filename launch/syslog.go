@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Syslog 把子进程的输出按行以 RFC 5424 格式发送到 syslog,与日志文件同时记录
+type Syslog struct {
+	// Addr unix:///dev/log、udp://host:514 或 tcp://host:514,为空时使用本机的 /dev/log
+	Addr string `json:"addr" yaml:"addr"`
+	// Facility kern|user|mail|daemon|auth|syslog|lpr|news|uucp|cron|authpriv|ftp|local0-7,默认 user
+	Facility string `json:"facility" yaml:"facility"`
+	// Severity stdout 的级别,默认 info;StderrSeverity stderr 的级别,默认 err
+	Severity       string `json:"severity" yaml:"severity"`
+	StderrSeverity string `json:"stderrseverity" yaml:"stderrseverity"`
+	// Tag 消息的 APP-NAME,默认为程序名
+	Tag string `json:"tag" yaml:"tag"`
+	// Buffer 等待发送的最大行数,默认 1024.缓冲满时丢弃新的行,不会阻塞子进程
+	Buffer int `json:"buffer" yaml:"buffer"`
+}
+
+const (
+	defaultSyslogAddr   = "unix:///dev/log"
+	defaultSyslogBuffer = 1024
+	// syslogDrainTimeout launch 退出时等待 syslog 队列发送完的时间
+	syslogDrainTimeout = 3 * time.Second
+	// syslogRetryMax 连接失败后重连间隔的上限
+	syslogRetryMax = 30 * time.Second
+	// syslogTimeFormat RFC 5424 的 TIMESTAMP,精确到微秒
+	syslogTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var syslogSeverities = map[string]int{
+	"emerg": 0, "alert": 1, "crit": 2, "err": 3, "error": 3,
+	"warning": 4, "warn": 4, "notice": 5, "info": 6, "debug": 7,
+}
+
+// parseSyslogAddr 解析 Addr,返回 net.Dial 使用的 network 和 address.
+// unix 先尝试 unixgram,/dev/log 通常是数据报 socket
+func parseSyslogAddr(addr string) (network, address string, err error) {
+	if addr == "" {
+		addr = defaultSyslogAddr
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", "", fmt.Errorf("bad syslog addr %q: %w", addr, err)
+	}
+	switch u.Scheme {
+	case "unix", "unixgram":
+		if u.Path == "" {
+			return "", "", fmt.Errorf("bad syslog addr %q, want unix:///path", addr)
+		}
+		return u.Scheme, u.Path, nil
+	case "udp", "tcp":
+		if u.Host == "" {
+			return "", "", fmt.Errorf("bad syslog addr %q, want %s://host:port", addr, u.Scheme)
+		}
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "514")
+		}
+		return u.Scheme, host, nil
+	}
+	return "", "", fmt.Errorf("bad syslog addr %q, want unix://, udp:// or tcp://", addr)
+}
+
+func (s *Syslog) validate() error {
+	if _, _, err := parseSyslogAddr(s.Addr); err != nil {
+		return err
+	}
+	if s.Facility == "" {
+		s.Facility = "user"
+	}
+	if _, ok := syslogFacilities[s.Facility]; !ok {
+		return fmt.Errorf("unknown syslog facility: %s", s.Facility)
+	}
+	if s.Severity == "" {
+		s.Severity = "info"
+	}
+	if s.StderrSeverity == "" {
+		s.StderrSeverity = "err"
+	}
+	for _, sev := range []string{s.Severity, s.StderrSeverity} {
+		if _, ok := syslogSeverities[sev]; !ok {
+			return fmt.Errorf("unknown syslog severity: %s", sev)
+		}
+	}
+	if s.Buffer < 0 {
+		return fmt.Errorf("bad syslog buffer: %d", s.Buffer)
+	}
+	return nil
+}
+
+// syslogMsg 等待发送的一行
+type syslogMsg struct {
+	time     time.Time
+	severity int
+	stream   string
+	pid      int
+	line     []byte
+}
+
+// syslogSink 一个 syslog 目的地.Write 只把消息放入有界的队列,
+// 由单独的 goroutine 连接并发送,连接断开时按退避间隔重连,
+// 队列满时丢弃新的消息并计数,慢的 syslog 不会阻塞子进程
+type syslogSink struct {
+	addr     string
+	network  string
+	address  string
+	facility int
+	tag      string
+	hostname string
+
+	queue chan syslogMsg
+	done  chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+
+	sent    atomic.Uint64
+	dropped atomic.Uint64
+}
+
+func newSyslogSink(s *Syslog, name string) *syslogSink {
+	network, address, _ := parseSyslogAddr(s.Addr)
+	tag := s.Tag
+	if tag == "" {
+		tag = name
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	n := s.Buffer
+	if n == 0 {
+		n = defaultSyslogBuffer
+	}
+	addr := s.Addr
+	if addr == "" {
+		addr = defaultSyslogAddr
+	}
+	sk := &syslogSink{
+		addr:     addr,
+		network:  network,
+		address:  address,
+		facility: syslogFacilities[s.Facility],
+		tag:      syslogField(tag, 48),
+		hostname: syslogField(hostname, 255),
+		queue:    make(chan syslogMsg, n),
+		done:     make(chan struct{}),
+	}
+	go sk.run()
+	return sk
+}
+
+// syslogField 把 HOSTNAME/APP-NAME 限制为 RFC 5424 允许的可打印 ASCII 和长度
+func syslogField(s string, max int) string {
+	b := []byte(s)
+	for i, c := range b {
+		if c <= ' ' || c >= 0x7f {
+			b[i] = '_'
+		}
+	}
+	if len(b) > max {
+		b = b[:max]
+	}
+	if len(b) == 0 {
+		return "-"
+	}
+	return string(b)
+}
+
+// send 把一行放入队列,队列满或已关闭时丢弃
+func (sk *syslogSink) send(m syslogMsg) {
+	sk.mu.Lock()
+	defer sk.mu.Unlock()
+	if sk.closed {
+		sk.dropped.Add(1)
+		return
+	}
+	select {
+	case sk.queue <- m:
+	default:
+		sk.dropped.Add(1)
+	}
+}
+
+// close 停止接收新的消息,等待队列中的消息发送完,最多等待 timeout
+func (sk *syslogSink) close(timeout time.Duration) {
+	sk.mu.Lock()
+	if !sk.closed {
+		sk.closed = true
+		close(sk.queue)
+	}
+	sk.mu.Unlock()
+	select {
+	case <-sk.done:
+	case <-time.After(timeout):
+		slog.Warn("syslog sink not drained", "addr", sk.addr, "pending", len(sk.queue))
+	}
+}
+
+func (sk *syslogSink) run() {
+	defer close(sk.done)
+	var (
+		conn    net.Conn
+		stream  bool
+		retry   time.Duration
+		lastErr error
+		// reported 上次记录日志时的丢弃数,丢弃增加时在发送成功后或退出时记录一次
+		reported uint64
+	)
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+		if d := sk.dropped.Load(); d != reported {
+			slog.Warn("syslog sink dropped lines", "addr", sk.addr, "dropped", d-reported)
+		}
+	}()
+	for m := range sk.queue {
+		for conn == nil {
+			var err error
+			if conn, stream, err = sk.dial(); err == nil {
+				if lastErr != nil {
+					slog.Info("syslog sink reconnected", "addr", sk.addr)
+				}
+				retry, lastErr = 0, nil
+				break
+			}
+			if lastErr == nil || lastErr.Error() != err.Error() {
+				slog.Warn("syslog sink unavailable", "addr", sk.addr, "err", err)
+			}
+			lastErr = err
+			retry = min(max(retry*2, time.Second), syslogRetryMax)
+			// 等待重连期间队列会被填满,之后的行被丢弃.关闭时不再重连
+			sk.mu.Lock()
+			closed := sk.closed
+			sk.mu.Unlock()
+			if closed {
+				sk.dropped.Add(uint64(len(sk.queue)) + 1)
+				for range sk.queue {
+				}
+				return
+			}
+			time.Sleep(retry)
+		}
+		msg := sk.format(m)
+		if stream {
+			// RFC 6587 octet counting
+			msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+		}
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if _, err := conn.Write(msg); err != nil {
+			slog.Warn("syslog sink write failed", "addr", sk.addr, "err", err)
+			conn.Close()
+			conn, lastErr = nil, err
+			sk.dropped.Add(1)
+			continue
+		}
+		sk.sent.Add(1)
+		if d := sk.dropped.Load(); d != reported {
+			slog.Warn("syslog sink dropped lines", "addr", sk.addr, "dropped", d-reported)
+			reported = d
+		}
+	}
+}
+
+// dial 连接 syslog,stream 表示面向流的连接,需要按 RFC 6587 分帧
+func (sk *syslogSink) dial() (conn net.Conn, stream bool, err error) {
+	if sk.network == "unix" {
+		// /dev/log 通常是 unixgram,不是时再按 unix stream 连接
+		if conn, err = net.DialTimeout("unixgram", sk.address, 5*time.Second); err == nil {
+			return conn, false, nil
+		}
+		conn, err = net.DialTimeout("unix", sk.address, 5*time.Second)
+		return conn, true, err
+	}
+	conn, err = net.DialTimeout(sk.network, sk.address, 5*time.Second)
+	return conn, sk.network == "tcp", err
+}
+
+// format 生成 RFC 5424 消息: <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG,
+// MSGID 为 stdout/stderr,没有结构化数据
+func (sk *syslogSink) format(m syslogMsg) []byte {
+	b := make([]byte, 0, len(m.line)+128)
+	b = append(b, '<')
+	b = strconv.AppendInt(b, int64(sk.facility*8+m.severity), 10)
+	b = append(b, ">1 "...)
+	b = m.time.AppendFormat(b, syslogTimeFormat)
+	b = append(b, ' ')
+	b = append(b, sk.hostname...)
+	b = append(b, ' ')
+	b = append(b, sk.tag...)
+	b = append(b, ' ')
+	if m.pid > 0 {
+		b = strconv.AppendInt(b, int64(m.pid), 10)
+	} else {
+		b = append(b, '-')
+	}
+	b = append(b, ' ')
+	b = append(b, m.stream...)
+	b = append(b, " - "...)
+	return append(b, m.line...)
+}
+
+// newSyslogWriter 把一个输出流按行发送到 sinks,超过 maxLine 的行被截断.
+// sinks 的队列满时丢弃,Write 不会返回错误,也不会阻塞
+func newSyslogWriter(sinks []*syslogSink, cfgs []*Syslog, stream string, maxLine int) *lineWriter {
+	severity := make([]int, len(cfgs)) // 与 sinks 一一对应
+	for i, c := range cfgs {
+		sev := c.Severity
+		if stream == "stderr" {
+			sev = c.StderrSeverity
+		}
+		severity[i] = syslogSeverities[sev]
+	}
+	return newLineSplitter(maxLine, func(line []byte, pid int, truncated bool) error {
+		// 每个 sink 的队列持有自己的副本,line 会被复用
+		line = bytes.Clone(line)
+		if truncated {
+			line = append(line, lineTruncatedMark...)
+		}
+		now := time.Now()
+		for i, sk := range sinks {
+			sk.send(syslogMsg{time: now, severity: severity[i], stream: stream, pid: pid, line: line})
+		}
+		return nil
+	})
+}
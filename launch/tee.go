@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+)
+
+// tee 输出的颜色,stdout 保持原样,stderr 为红色
+const (
+	teeColorStderr = "\033[31m"
+	teeColorReset  = "\033[0m"
+)
+
+// teeMu 多个子进程同时 tee 时,保证每次写入完整地输出到终端
+var teeMu sync.Mutex
+
+// teeWriter 把子进程的原始输出同时写到 launch 自己的 stdout/stderr.
+// 写终端失败(例如终端已关闭)不影响日志,错误被忽略
+type teeWriter struct {
+	w     io.Writer
+	color string // 非空时每次写入用该颜色包围
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	out := p
+	if t.color != "" {
+		out = make([]byte, 0, len(t.color)+len(p)+len(teeColorReset))
+		// 颜色在换行前结束,不影响终端的下一行
+		body, nl := bytes.CutSuffix(p, []byte("\n"))
+		out = append(out, t.color...)
+		out = append(out, body...)
+		out = append(out, teeColorReset...)
+		if nl {
+			out = append(out, '\n')
+		}
+	}
+	teeMu.Lock()
+	t.w.Write(out)
+	teeMu.Unlock()
+	return len(p), nil
+}
+
+// pumpStdin PTY 模式下把 launch 的 stdin 转发到当前子进程的终端,
+// 子进程重启后转发到新的终端.stdin 结束时发送 ^D,子进程读到 EOF
+func (p *program) pumpStdin() {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := os.Stdin.Read(buf)
+		p.mu.Lock()
+		w := p.ptyIn
+		p.mu.Unlock()
+		if n > 0 && w != nil {
+			w.Write(buf[:n])
+		}
+		if err != nil {
+			if w != nil {
+				w.Write([]byte{0x04})
+			}
+			return
+		}
+	}
+}
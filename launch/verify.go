@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ndsky1003/cmd/common/logrotate"
+)
+
+// runVerify 根据备份目录中的 manifest 校验备份是否缺失、被截断或被修改,返回退出码.
+// 有配置文件时校验其中所有日志,否则校验 -dir/-filename(以及 -errfilename)指定的日志
+func runVerify(cfg *Config, args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	quiet := fs.Bool("q", false, "only print failures")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: launch [-c config | -dir dir -filename name] verify [-q] [name]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	name := fs.Arg(0)
+
+	var logs []*logrotate.Logger
+	if cfg != nil {
+		if name == "" || name == "launch" {
+			logs = append(logs, cfg.Log)
+		}
+		for _, pc := range cfg.Programs {
+			if name != "" && pc.Name != name {
+				continue
+			}
+			logs = append(logs, pc.Log)
+			if pc.Stderr != nil {
+				logs = append(logs, pc.Stderr)
+			}
+		}
+		if len(logs) == 0 {
+			fmt.Fprintf(os.Stderr, "no such program: %s\n", name)
+			return 1
+		}
+	} else {
+		logs = append(logs, logger)
+		if errLogger.Filename != "" {
+			if errLogger.BackDir == "" {
+				errLogger.BackDir = logger.BackDir
+			}
+			logs = append(logs, errLogger)
+		}
+	}
+
+	verified, failed := 0, 0
+	for _, l := range logs {
+		results, err := l.Verify()
+		if errors.Is(err, logrotate.ErrNoManifest) {
+			fmt.Fprintf(os.Stderr, "%s: no manifest in %s\n", l.Filename, filepath.Dir(l.ManifestName()))
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", l.Filename, err)
+			failed++
+			continue
+		}
+		for _, r := range results {
+			verified++
+			path := filepath.Join(filepath.Dir(l.ManifestName()), r.Name)
+			if r.Err != nil {
+				failed++
+				fmt.Printf("FAIL %s: %v\n", path, r.Err)
+			} else if !*quiet {
+				from := "-"
+				if !r.From.IsZero() {
+					from = r.From.Format(time.RFC3339)
+				}
+				fmt.Printf("ok   %s %s ~ %s %d lines\n", path, from, r.To.Format(time.RFC3339), r.Lines)
+			}
+		}
+	}
+	fmt.Printf("%d backups verified, %d failed\n", verified, failed)
+	if failed > 0 || verified == 0 {
+		return 1
+	}
+	return 0
+}